@@ -0,0 +1,429 @@
+// Package evaltest holds the programs the tests of the evaluator run, along
+// with the results they expect, so that the tests of the virtual machine can
+// check that both engines agree on them.
+package evaltest
+
+// Inputs returns the programs of every table.
+func Inputs() []string {
+	var inputs []string
+	for _, tt := range Integers {
+		inputs = append(inputs, tt.Input)
+	}
+	for _, tt := range Floats {
+		inputs = append(inputs, tt.Input)
+	}
+	for _, tt := range Booleans {
+		inputs = append(inputs, tt.Input)
+	}
+	for _, tt := range BangOperators {
+		inputs = append(inputs, tt.Input)
+	}
+	for _, tt := range IfElseExpressions {
+		inputs = append(inputs, tt.Input)
+	}
+	for _, tt := range ReturnStatements {
+		inputs = append(inputs, tt.Input)
+	}
+	for _, tt := range Errors {
+		inputs = append(inputs, tt.Input)
+	}
+	for _, tt := range ErrorPositions {
+		inputs = append(inputs, tt.Input)
+	}
+	for _, tt := range VarStatements {
+		inputs = append(inputs, tt.Input)
+	}
+	for _, tt := range FunctionApplications {
+		inputs = append(inputs, tt.Input)
+	}
+	for _, tt := range Builtins {
+		inputs = append(inputs, tt.Input)
+	}
+	for _, tt := range CollectionBuiltins {
+		inputs = append(inputs, tt.Input)
+	}
+	for _, tt := range Loops {
+		inputs = append(inputs, tt.Input)
+	}
+	for _, tt := range Assignments {
+		inputs = append(inputs, tt.Input)
+	}
+	for _, tt := range ArrayEquality {
+		inputs = append(inputs, tt.Input)
+	}
+	for _, tt := range IndexExpressions {
+		inputs = append(inputs, tt.Input)
+	}
+	for _, tt := range HashIndexExpressions {
+		inputs = append(inputs, tt.Input)
+	}
+
+	return inputs
+}
+
+// Integers evaluate to the given integer.
+var Integers = []struct {
+	Input    string
+	Expected int64
+}{
+	{"5", 5},
+	{"10", 10},
+	{"-5", -5},
+	{"-10", -10},
+	{"5 + 5 + 5 + 5 - 10", 10},
+	{"2 * 2 * 2 * 2 * 2", 32},
+	{"-50 + 100 + -50", 0},
+	{"5 * 2 + 10", 20},
+	{"5 + 2 * 10", 25},
+	{"20 + 2 * -10", 0},
+	{"50 / 2 * 2 + 10", 60},
+	{"2 * (5 + 10)", 30},
+	{"3 * 3 * 3 + 10", 37},
+	{"3 * (3 * 3) + 10", 37},
+	{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+}
+
+// Floats evaluate to the given float.
+var Floats = []struct {
+	Input    string
+	Expected float64
+}{
+	{"3.14", 3.14},
+	{"-2.5", -2.5},
+	{"1e3", 1000},
+	{"1.5 + 1.5", 3},
+	{"1 + 0.5", 1.5},
+	{"0.5 * 4", 2},
+	{"7 / 2.0", 3.5},
+	{"10 - 2.5 * 2", 5},
+}
+
+// Booleans evaluate to the given boolean.
+var Booleans = []struct {
+	Input    string
+	Expected bool
+}{
+	{"true", true},
+	{"false", false},
+	{"1 < 2", true},
+	{"1 > 2", false},
+	{"1 < 1", false},
+	{"1 > 1", false},
+	{"1 == 1", true},
+	{"1 != 1", false},
+	{"1 == 2", false},
+	{"1 != 2", true},
+	{"true == true", true},
+	{"false == false", true},
+	{"true == false", false},
+	{"true != false", true},
+	{"false != true", true},
+	{"(1 < 2) == true", true},
+	{"(1 > 2) == true", false},
+	{"(1 > 2) == false", true},
+	{"1.5 < 2", true},
+	{"2 > 1.5", true},
+	{"1 == 1.0", true},
+	{"0.1 + 0.2 != 0.3", true},
+	{"1 <= 2", true},
+	{"2 <= 2", true},
+	{"3 <= 2", false},
+	{"1 >= 2", false},
+	{"2 >= 2", true},
+	{"2.5 >= 2", true},
+	{"1 <= 0.5", false},
+	{"true && true", true},
+	{"true && false", false},
+	{"false || true", true},
+	{"false || false", false},
+	{"1 && \"a\"", true},
+	{"if (false) { 1 } || 0", true},
+	{"1 < 2 && 2 < 3 || false", true},
+	{"false && undefined", false},
+	{"true || undefined", true},
+}
+
+// BangOperators evaluate to the given boolean.
+var BangOperators = []struct {
+	Input    string
+	Expected bool
+}{
+	{"!true", false},
+	{"!false", true},
+	{"!5", false},
+	{"!!true", true},
+	{"!!false", false},
+	{"!!5", true},
+}
+
+// IfElseExpressions evaluate to the given int, or null when nil.
+var IfElseExpressions = []struct {
+	Input    string
+	Expected interface{}
+}{
+	{"if (true) { 10 }", 10},
+	{"if (false) { 10 }", nil},
+	{"if (1) { 10 }", 10},
+	{"if (1 < 2) { 10 }", 10},
+	{"if (1 > 2) { 10 }", nil},
+	{"if (1 > 2) { 10 } else { 20 }", 20},
+	{"if (1 < 2) { 10 } else { 20 }", 10},
+}
+
+// ReturnStatements evaluate to the given integer.
+var ReturnStatements = []struct {
+	Input    string
+	Expected int64
+}{
+	{"return 10;", 10},
+	{"return 10; 9", 10},
+	{"return 2 * 5; 9", 10},
+	{"9; return 2 * 5; 9", 10},
+	{`if (10 > 1) {
+		if (10 > 1) {
+			return 10;
+		}
+
+		return 1;
+	}
+	`, 10},
+}
+
+// Errors fail with the given message.
+var Errors = []struct {
+	Input    string
+	Expected string
+}{
+	{"5 + true", "type mismatch: INTEGER + BOOLEAN"},
+	{"5 + true; 5;", "type mismatch: INTEGER + BOOLEAN"},
+	{"-true", "unknown operator: -BOOLEAN"},
+	{"true + false", "unknown operator: BOOLEAN + BOOLEAN"},
+	{"5; true + false; 5", "unknown operator: BOOLEAN + BOOLEAN"},
+	{"if (10 > 1) { true + false; }", "unknown operator: BOOLEAN + BOOLEAN"},
+	{
+		`if (10 > 1) {
+			if (10 > 1) {
+				return true + false;
+			}
+		return 1;
+		}`,
+		"unknown operator: BOOLEAN + BOOLEAN"},
+	{"foobar", "identifier not found: foobar"},
+	{`"Hello" - "World"`, "unknown operator: STRING - STRING"},
+	{"[1, 2][true]", "index operator not supported: ARRAY[BOOLEAN]"},
+	{"5[0]", "index operator not supported: INTEGER[INTEGER]"},
+	{`{"name": "Monkey"}[fn(x) { x }];`, "unusable as hash key: FUNC"},
+	{`{[1, 2]: "Monkey"}`, "unusable as hash key: ARRAY"},
+	{"1 / 0", "division by zero"},
+	{"var zero = 5 - 5; 10 / zero * 2", "division by zero"},
+	{"fn(a, b) { a + b }(1)", "wrong number of arguments: want=2, got=1"},
+	{"var f = fn() { 1 }; f(1, 2)", "wrong number of arguments: want=0, got=2"},
+}
+
+// ErrorPositions fail with the given inspected error.
+var ErrorPositions = []struct {
+	Input    string
+	Expected string
+}{
+	{"5 + true", "error: 1:1: type mismatch: INTEGER + BOOLEAN"},
+	{"var a = 1;\n  foobar", "error: 2:3: identifier not found: foobar"},
+	{"var f = fn() {\n  -true\n};\nf()", "error: 2:3: unknown operator: -BOOLEAN"},
+}
+
+// VarStatements evaluate to the given integer.
+var VarStatements = []struct {
+	Input    string
+	Expected int64
+}{
+	{"var a = 5; a;", 5},
+	{"var a = 5 * 5; a;", 25},
+	{"var a = 5; var b = a; b;", 5},
+	{"var a = 5; var b = a; var c = a + b + 5; c;", 15},
+}
+
+// FunctionApplications evaluate to the given integer.
+var FunctionApplications = []struct {
+	Input    string
+	Expected int64
+}{
+	{"var identity = fn(x) { x; }; identity(5);", 5},
+	{"var identity = fn(x) { return x; }; identity(5);", 5},
+	{"var double = fn(x) { x * 2; }; double(5);", 10},
+	{"var add = fn(x, y) { x + y; }; add(5, 5);", 10},
+	{"var add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
+	{"fn(x) { x; }(5)", 5},
+}
+
+// Builtins evaluate to the given int or fail with the given message.
+var Builtins = []struct {
+	Input    string
+	Expected interface{}
+}{
+	{`len("")`, 0},
+	{`len("four")`, 4},
+	{`len("hello world")`, 11},
+	{`len(1)`, "argument to `len` not supported, got INTEGER"},
+	{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+	{`len([])`, 0},
+	{`len([1, 2, 3])`, 3},
+	{`len({"a": 1, "b": 2})`, 2},
+	{`len("ñandú")`, 5},
+	{`bytelen("ñandú")`, 7},
+	{`bytelen("four")`, 4},
+	{`bytelen([1])`, "argument to `bytelen` must be STRING, got ARRAY"},
+}
+
+// CollectionBuiltins evaluate to a value with the given inspected form or fail
+// with the given message.
+var CollectionBuiltins = []struct {
+	Input    string
+	Expected string // inspected result or error message
+}{
+	{"first([1, 2, 3])", "1"},
+	{"first([])", "null"},
+	{"first(1)", "argument to `first` must be ARRAY, got INTEGER"},
+	{"last([1, 2, 3])", "3"},
+	{"last([])", "null"},
+	{"rest([1, 2, 3])", "[2, 3]"},
+	{"rest([1])", "[]"},
+	{"rest([])", "null"},
+	{"var a = [1]; var b = push(a, 2); [a, b]", "[[1], [1, 2]]"},
+	{"push([1])", "wrong number of arguments. got=1, want=2"},
+	{"concat([1], [], [2, 3])", "[1, 2, 3]"},
+	{"concat([1], 2)", "arguments to `concat` must be ARRAY, got INTEGER"},
+	{"slice([1, 2, 3, 4], 1)", "[2, 3, 4]"},
+	{"slice([1, 2, 3, 4], 1, 3)", "[2, 3]"},
+	{"slice([1, 2, 3], -5, 10)", "[1, 2, 3]"},
+	{"slice([1, 2, 3], 2, 1)", "[]"},
+	{`slice("ñandú", 1, 3)`, "an"},
+	{`slice([1], "a")`, "bounds of `slice` must be INTEGER, got STRING"},
+	{"reverse([1, 2, 3])", "[3, 2, 1]"},
+	{`reverse("ñandú")`, "údnañ"},
+	{"reverse(1)", "argument to `reverse` must be ARRAY or STRING, got INTEGER"},
+	{"sort([3, 1.5, 2])", "[1.5, 2, 3]"},
+	{`sort(["b", "c", "a"])`, "[a, b, c]"},
+	{`sort([1, "a"])`, "`sort` can't compare STRING and INTEGER, pass a comparison function"},
+	{"sort([1, 3, 2], fn(a, b) { a > b })", "[3, 2, 1]"},
+	{`sort([[2, "a"], [1, "b"], [2, "c"]], fn(a, b) { a[0] < b[0] })`, "[[1, b], [2, a], [2, c]]"},
+	{"sort([1, 2], fn(a, b) { 1 })", "comparison function of `sort` must return BOOLEAN, got INTEGER"},
+	{"map([1, 2, 3], fn(x) { x * 2 })", "[2, 4, 6]"},
+	{"var n = 10; map([1, 2], fn(x) { x + n })", "[11, 12]"},
+	{"map([1, 2], len)", "argument to `len` not supported, got INTEGER"},
+	{"map([1, 0], fn(x) { 1 / x })", "division by zero"},
+	{"map([1], 1)", "not a function: INTEGER"},
+	{"filter([1, 2, 3, 4], fn(x) { x > 2 })", "[3, 4]"},
+	{"filter([0, first([]), false, true], fn(x) { x })", "[0, true]"},
+	{"reduce([1, 2, 3], 0, fn(acc, x) { acc + x })", "6"},
+	{"reduce([], 5, fn(acc, x) { acc + x })", "5"},
+	{"reduce([1], 0)", "wrong number of arguments. got=2, want=3"},
+}
+
+// Loops evaluate to the given int, string, integer array or null when nil, or
+// fail with the given message.
+var Loops = []struct {
+	Input    string
+	Expected interface{}
+}{
+	{"var i = 0; while (i < 5) { var i = i + 1; } i", 5},
+	{"var i = 0; while (true) { var i = i + 1; if (i == 3) { break; } } i", 3},
+	{"var n = 0; var i = 0; while (i < 5) { var i = i + 1; if (i == 2) { continue } var n = n + i; } n", 13},
+	{"var n = 0; for (x in [1, 2, 3]) { var n = n + x; } n", 6},
+	{"var n = 0; for (x in range(10)) { if (x > 3) { break } var n = n + x; } n", 6},
+	{`var s = ""; for (c in "abc") { var s = c + s; } s`, "cba"},
+	{`var s = ""; for (k in {"b": 1, "a": 2}) { var s = s + k; } s`, "ab"},
+	{"var f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } }; f()", 20},
+	{"var n = 0; for (i in range(3)) { for (j in range(3)) { if (j == i) { break } var n = n + 1; } } n", 3},
+	{"while (false) { 1 }", nil},
+	{"var x = 10; for (x in [1, 2]) { var n = x; } [x, n]", []int64{10, 2}},
+	{"var f = fn() { var x = 10; for (x in [1, 2]) { x += 1 } x }; f()", 10},
+	{"for (x in [1, 2]) { } x", "identifier not found: x"},
+	{"for (x in 5) { x }", "not iterable: INTEGER"},
+	{"for (x in [1, 0]) { 1 / x }", "division by zero"},
+	{"range(2, 8, 3)", []int64{2, 5}},
+	{"range(3, 0, -1)", []int64{3, 2, 1}},
+	{"range(1, 2, 0)", "`range` step must not be zero"},
+	{"range(9223372036854775806, 9223372036854775807, 2)", []int64{9223372036854775806}},
+	{"range(-9223372036854775807, -9223372036854775807 - 1, -5)", []int64{-9223372036854775807}},
+	{"range(-9223372036854775807 - 1, 9223372036854775807)", "`range` too large, got more than 67108864 elements"},
+}
+
+// Assignments evaluate to the given int, string or null when nil, or fail with
+// the given message.
+var Assignments = []struct {
+	Input    string
+	Expected interface{}
+}{
+	{"var x = 1; x = 2; x", 2},
+	{"var x = 1; x += 2; x -= 1; x *= 5; x /= 2; x", 5},
+	{`var s = "a"; s += "b"; s`, "ab"},
+	{"var x = 1; var f = fn() { x = 10; }; f(); x", 10},
+	{"var make = fn() { var n = 0; fn() { n += 1; n } }; var c = make(); c(); c(); c()", 3},
+	{"var f = fn() { var n = 0; var inc = fn() { n += 1 }; inc(); inc(); n }; f()", 2},
+	{"var fs = fn() { var n = 0; [fn() { n += 1 }, fn() { n }] }(); fs[0](); fs[0](); fs[1]()", 2},
+	{"var f = fn(x) { x += 1; x }; f(1)", 2},
+	{"var f = fn() { f = 1 }; f(); f", 1},
+	{"var f = fn() { f = 1; f }; f()", 1},
+	{"var g = fn() { var f = fn() { f = 2; 5 }; f() + f }; g()", 7},
+	{"var f = fn() { 1; var x = 2; x = 3 }; f()", nil},
+	{"var a = [1, 2, 3]; a[1] = 5; a[1] + a[2]", 8},
+	{"var a = [1, 2, 3]; var b = a; b[0] += 10; a[0]", 11},
+	{`var h = {"a": 1}; h["b"] = 2; h["a"] *= 7; h["a"] + h["b"]`, 9},
+	{"var n = 0; for (x in range(5)) { n += x; } n", 10},
+	{"y = 1", "cannot assign to undeclared identifier: y"},
+	{"var x = 1; x += true", "type mismatch: INTEGER + BOOLEAN"},
+	{"var a = [1]; a[1] = 2", "index out of range: 1"},
+	{"var a = [1]; a[-1] += 2", "index out of range: -1"},
+	{`var a = [1]; a["x"] = 2`, "index operator not supported: ARRAY[STRING]"},
+	{`var s = "abc"; s[0] = "x"`, "index assignment not supported: STRING"},
+	{`var h = {}; h[[1]] = 2`, "unusable as hash key: ARRAY"},
+}
+
+// ArrayEquality compares arrays, evaluating to the given boolean.
+var ArrayEquality = []struct {
+	Input    string
+	Expected bool
+}{
+	{"[] == []", true},
+	{"[1, 2] == [1, 2]", true},
+	{"[1, 2] == [2, 1]", false},
+	{"[1, 2] != [1, 2, 3]", true},
+	{`[[1, "a"], true] == [[1, "a"], true]`, true},
+	{`[[1, "a"]] == [[1, "b"]]`, false},
+}
+
+// IndexExpressions evaluate to the given int, string, or null when nil.
+var IndexExpressions = []struct {
+	Input    string
+	Expected interface{}
+}{
+	{"[1, 2, 3][0]", 1},
+	{"[1, 2, 3][1]", 2},
+	{"[1, 2, 3][2]", 3},
+	{"var i = 0; [1][i];", 1},
+	{"[1, 2, 3][1 + 1];", 3},
+	{"var myArray = [1, 2, 3]; myArray[2];", 3},
+	{"var myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];", 6},
+	{"var myArray = [1, 2, 3]; var i = myArray[0]; myArray[i]", 2},
+	{"[1, 2, 3][3]", nil},
+	{"[1, 2, 3][-1]", nil},
+	{`"monkey"[0]`, "m"},
+	{`var name = "monkey"; name[5]`, "y"},
+	{`"monkey"[6]`, nil},
+	{`"monkey"[-1]`, nil},
+	{`"ñandú"[4]`, "ú"},
+	{`"🐒!"[1]`, "!"},
+}
+
+// HashIndexExpressions evaluate to the given int, or null when nil.
+var HashIndexExpressions = []struct {
+	Input    string
+	Expected interface{}
+}{
+	{`{"foo": 5}["foo"]`, 5},
+	{`{"foo": 5}["bar"]`, nil},
+	{`var key = "foo"; {"foo": 5}[key]`, 5},
+	{`{}["foo"]`, nil},
+	{`{5: 5}[5]`, 5},
+	{`{true: 5}[true]`, 5},
+	{`{false: 5}[false]`, 5},
+}
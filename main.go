@@ -1,20 +1,327 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"monkey/pkg/engine"
+	"monkey/pkg/format"
+	"monkey/pkg/lexer"
+	"monkey/pkg/lsp"
+	"monkey/pkg/object"
+	"monkey/pkg/parser"
 	"monkey/pkg/repl"
 	"os"
 	"os/user"
 )
 
+// Exit codes returned by the monkey command.
+const (
+	exitOK           = 0
+	exitRuntimeError = 1 // the program evaluated to an error
+	exitUnformatted  = 1 // fmt -check found files that aren't formatted
+	exitServerError  = 1 // the language server lost its connection
+	exitUsage        = 2 // the command line could not be understood
+	exitParseError   = 3 // the program could not be parsed
+)
+
+const usage = `usage:
+  monkey [flags]                      start the interactive REPL
+  monkey [flags] repl                 start the interactive REPL
+  monkey [flags] [run] file [args...] run a script
+  monkey [flags] -e expr [args...]    evaluate an expression and print its value
+  monkey fmt [-check] [-w] [files...] format scripts, see "monkey fmt -h"
+  monkey lsp                          run a language server on stdin and stdout
+
+Script arguments are available to the program in the "args" array.
+
+flags:
+`
+
 func main() {
-	u, err := user.Current()
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the monkey command with the given arguments and returns the
+// process exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("monkey", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	expr := flags.String("e", "", "evaluate `expr` instead of reading a script")
+	quiet := flags.Bool("q", false, "don't print the banner and prompts")
+	engineName := flags.String("engine", engine.Eval, "execution `engine`: eval or vm")
+	maxSteps := flags.Int("max-steps", 0, "stop programs after `n` evaluation steps, 0 for no limit")
+	maxDepth := flags.Int("max-depth", 0, "stop programs nesting more than `n` calls, 0 for the default")
+
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	rest := flags.Args()
+
+	if len(rest) > 0 && rest[0] == "fmt" {
+		return formatFiles(rest[1:], stdin, stdout, stderr)
+	}
+
+	if len(rest) > 0 && rest[0] == "lsp" {
+		if len(rest) > 1 {
+			flags.Usage()
+			return exitUsage
+		}
+
+		if err := lsp.Serve(stdin, stdout); err != nil {
+			fmt.Fprintf(stderr, "monkey lsp: %s\n", err)
+			return exitServerError
+		}
+		return exitOK
+	}
+
+	e, err := engine.New(*engineName)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return exitUsage
+	}
+	e.SetStdout(stdout)
+	e.SetStderr(stderr)
+	e.SetLimits(object.Limits{MaxSteps: *maxSteps, MaxDepth: *maxDepth})
+
+	exprSet := false
+	flags.Visit(func(f *flag.Flag) { exprSet = exprSet || f.Name == "e" })
+
+	if exprSet {
+		return execute(e, "-e", *expr, rest, stdout, stderr, true)
+	}
+
+	if len(rest) == 0 || rest[0] == "repl" {
+		if len(rest) > 1 {
+			flags.Usage()
+			return exitUsage
+		}
+
+		startRepl(e, stdin, stdout, *quiet)
+		return exitOK
+	}
+
+	if rest[0] == "run" {
+		rest = rest[1:]
+		if len(rest) == 0 {
+			fmt.Fprintln(stderr, "monkey run: missing script file")
+			return exitUsage
+		}
+	}
+
+	src, err := os.ReadFile(rest[0])
 	if err != nil {
-		log.Fatalln(err)
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return exitUsage
+	}
+
+	return execute(e, rest[0], string(src), rest[1:], stdout, stderr, false)
+}
+
+func startRepl(e engine.Engine, in io.Reader, out io.Writer, quiet bool) {
+	if quiet {
+		repl.Run(in, out, repl.Config{Engine: e})
+		return
+	}
+
+	name := "there"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+
+	fmt.Fprintf(out, "Hello %s! This is the Monkey Programming Language!\n", name)
+	fmt.Fprintln(out, "Feel free to type in any command")
+	repl.Run(in, out, repl.Config{
+		Prompt:             repl.PROMPT,
+		ContinuationPrompt: repl.CONTINUATION_PROMPT,
+		Engine:             e,
+	})
+}
+
+// execute parses src and runs it on the engine with the script arguments
+// bound to "args". When printResult is set the value of the program is
+// written to stdout.
+func execute(
+	e engine.Engine,
+	filename, src string,
+	args []string,
+	stdout, stderr io.Writer,
+	printResult bool,
+) int {
+	p := parser.New(lexer.NewFile(filename, src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printDiagnostics(stderr, p.Errors())
+		return exitParseError
+	}
+
+	e.Define("args", scriptArgs(args))
+
+	result := e.Run(program)
+	if errObj, ok := result.(*object.Error); ok {
+		fmt.Fprint(stderr, errObj.Traceback())
+		fmt.Fprintln(stderr, errObj.Inspect())
+		return exitRuntimeError
+	}
+
+	if printResult && result != nil && result.Type() != object.NULL_OBJ {
+		fmt.Fprintln(stdout, result.Inspect())
+	}
+
+	return exitOK
+}
+
+func printDiagnostics(w io.Writer, diagnostics []parser.Diagnostic) {
+	for _, d := range diagnostics {
+		fmt.Fprintln(w, d)
+		if d.Hint != "" {
+			fmt.Fprintf(w, "\thint: %s\n", d.Hint)
+		}
+	}
+}
+
+func scriptArgs(args []string) *object.Array {
+	elements := make([]object.Object, len(args))
+	for i, arg := range args {
+		elements[i] = &object.String{Value: arg}
+	}
+
+	return &object.Array{Elements: elements}
+}
+
+const fmtUsage = `usage: monkey fmt [-check] [-w] [files...]
+
+Formats the given scripts, or the standard input when there are none, and
+prints the result.
+
+flags:
+`
+
+// formatFiles implements the fmt subcommand.
+func formatFiles(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("monkey fmt", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, fmtUsage)
+		flags.PrintDefaults()
+	}
+
+	check := flags.Bool("check", false, "list the files that aren't formatted instead of printing them")
+	write := flags.Bool("w", false, "write the result back to the files instead of printing it")
+
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	if *check && *write {
+		fmt.Fprintln(stderr, "monkey fmt: -check and -w can't be used together")
+		return exitUsage
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		if *write {
+			fmt.Fprintln(stderr, "monkey fmt: -w needs files to write to")
+			return exitUsage
+		}
+
+		src, err := io.ReadAll(stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "monkey fmt: %s\n", err)
+			return exitUsage
+		}
+
+		return formatSource("<stdin>", src, *check, stdout, stderr)
+	}
+
+	code := exitOK
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(stderr, "monkey fmt: %s\n", err)
+			code = exitUsage
+			continue
+		}
+
+		var c int
+		if *write {
+			c = formatInPlace(file, src, stderr)
+		} else {
+			c = formatSource(file, src, *check, stdout, stderr)
+		}
+		if code == exitOK {
+			code = c
+		}
+	}
+
+	return code
+}
+
+// formatSource prints the formatted src, or only its name when check is set
+// and the source isn't formatted.
+func formatSource(name string, src []byte, check bool, stdout, stderr io.Writer) int {
+	out, err := formatted(name, src, stderr)
+	if err != nil {
+		return exitParseError
+	}
+
+	if !check {
+		stdout.Write(out)
+		return exitOK
+	}
+
+	if !bytes.Equal(src, out) {
+		fmt.Fprintln(stdout, name)
+		return exitUnformatted
+	}
+
+	return exitOK
+}
+
+// formatInPlace rewrites the file if it isn't formatted.
+func formatInPlace(file string, src []byte, stderr io.Writer) int {
+	out, err := formatted(file, src, stderr)
+	if err != nil {
+		return exitParseError
+	}
+
+	if bytes.Equal(src, out) {
+		return exitOK
+	}
+
+	info, err := os.Stat(file)
+	if err == nil {
+		err = os.WriteFile(file, out, info.Mode().Perm())
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey fmt: %s\n", err)
+		return exitUsage
+	}
+
+	return exitOK
+}
+
+func formatted(name string, src []byte, stderr io.Writer) ([]byte, error) {
+	out, err := format.Source(name, src)
+
+	var parseErr *format.ParseError
+	if errors.As(err, &parseErr) {
+		printDiagnostics(stderr, parseErr.Diagnostics)
 	}
 
-	fmt.Printf("Hello %s! This is the Monkey Programming Language!\n", u.Username)
-	fmt.Println("Feel free to type in any command")
-	repl.Start(os.Stdin, os.Stdout)
+	return out, err
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.mk")
+	broken := filepath.Join(dir, "broken.mk")
+	failing := filepath.Join(dir, "failing.mk")
+
+	files := map[string]string{
+		script:  `var x = len(args); x;`,
+		broken:  "var x = 1;\nvar = 2;",
+		failing: "var x = 1;\nx + true;",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(name, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// recursion with changing arguments, whose traceback is cut short
+	deep := "var f = fn(n) { f(n + 1) + 1 }; f(1)"
+	deepTraceback := "Traceback (most recent call last):\n  -e:1:33, in <main>: f(1)\n"
+	for n := 2; n <= 31; n++ {
+		if n == 11 {
+			deepTraceback += "  [11 more lines]\n"
+			n = 22
+		}
+		deepTraceback += fmt.Sprintf("  -e:1:17, in f: f(%d)\n", n)
+	}
+	deepTraceback += "error: -e:1:17: maximum recursion depth exceeded: more than 30 nested calls\n"
+
+	tests := []struct {
+		args           []string
+		stdin          string
+		expectedCode   int
+		expectedStdout string
+		expectedStderr string
+	}{
+		{[]string{"-e", "1 + 2"}, "", exitOK, "3\n", ""},
+		{[]string{"-e", "args[1]", "a", "b"}, "", exitOK, "b\n", ""},
+		{[]string{"-e", "if (false) { 1 }"}, "", exitOK, "", ""},
+		{[]string{"-e", `printf("%d!\n", 3)`}, "", exitOK, "3!\n", ""},
+		{[]string{"-engine", "vm", "-e", `puts("vm")`}, "", exitOK, "vm\n", ""},
+		{[]string{"-e", "1 +"}, "", exitParseError, "", "-e:1:4: error: no prefix parse function for EOF found\n"},
+		{[]string{"-e", "-true"}, "", exitRuntimeError, "", "error: -e:1:1: unknown operator: -BOOLEAN\n"},
+		{[]string{script, "a", "b"}, "", exitOK, "", ""},
+		{[]string{"run", script}, "", exitOK, "", ""},
+		{[]string{"run", broken}, "", exitParseError, "", broken + ":2:5: error: expected next token to be IDENT, got== instead\n"},
+		{[]string{failing}, "", exitRuntimeError, "", "error: " + failing + ":2:1: type mismatch: INTEGER + BOOLEAN\n"},
+		{[]string{"-e", "var f = fn(x) { x / 0 };\nf(2)"}, "", exitRuntimeError, "", "Traceback (most recent call last):\n  -e:2:1, in <main>: f(2)\nerror: -e:1:17: division by zero\n"},
+		{[]string{"run"}, "", exitUsage, "", "monkey run: missing script file\n"},
+		{[]string{"-q"}, "1 + 1\n", exitOK, "2\n", ""},
+		{[]string{"-q", "repl"}, "var a = 2; a * 3\n", exitOK, "6\n", ""},
+		{[]string{"-engine", "vm", "-e", "args[0] + args[1]", "a", "b"}, "", exitOK, "ab\n", ""},
+		{[]string{"-engine", "vm", "-e", "-true"}, "", exitRuntimeError, "", "error: -e:1:1: unknown operator: -BOOLEAN\n"},
+		{[]string{"-engine", "vm", "-e", "var f = fn(x) { x / 0 };\nf(2)"}, "", exitRuntimeError, "", "Traceback (most recent call last):\n  -e:2:1, in <main>: f(2)\nerror: -e:1:17: division by zero\n"},
+		{[]string{"-engine", "vm", "-e", "var f = fn(x) { len(x) };\nmap([1], f)"}, "", exitRuntimeError, "", "Traceback (most recent call last):\n  -e:2:1, in <main>: map([1], fn f)\n  -e:1:17, in map: len(1)\nerror: -e:1:17: argument to `len` not supported, got INTEGER\n"},
+		{[]string{"-engine", "vm", "-e", "x"}, "", exitRuntimeError, "", "error: -e:1:1: identifier not found: x\n"},
+		{[]string{"-engine", "vm", script, "a"}, "", exitOK, "", ""},
+		{[]string{"-engine", "vm", "-q"}, "var a = 2;\na * 3\n", exitOK, "6\n", ""},
+		{[]string{"-max-steps", "1000", "-e", "var f = fn(x) { f(x) }; f(1)"}, "", exitRuntimeError, "", "Traceback (most recent call last):\n  -e:1:25, in <main>: f(1)\n  -e:1:17, in f: f(1)\n  -e:1:17, in f: f(1)\n  -e:1:17, in f: f(1)\n  [previous line repeated 61 more times]\nerror: -e:1:19: execution budget exceeded: more than 1000 steps\n"},
+		{[]string{"-max-depth", "1", "-e", "var f = fn(x) { 1 + f(x) }; f(1)"}, "", exitRuntimeError, "", "Traceback (most recent call last):\n  -e:1:29, in <main>: f(1)\n  -e:1:21, in f: f(1)\nerror: -e:1:21: maximum recursion depth exceeded: more than 1 nested calls\n"},
+		// long tracebacks only show both ends of the stack
+		{[]string{"-max-depth", "30", "-e", deep}, "", exitRuntimeError, "", deepTraceback},
+		{[]string{"-engine", "vm", "-max-depth", "30", "-e", deep}, "", exitRuntimeError, "", deepTraceback},
+		{[]string{"-engine", "jit", "-e", "1"}, "", exitUsage, "", "monkey: unknown engine \"jit\"\n"},
+		{[]string{"lsp"}, "Content-Length: 33\r\n\r\n{\"jsonrpc\":\"2.0\",\"method\":\"exit\"}", exitOK, "", ""},
+		{[]string{"lsp"}, "Content-Length: x\r\n\r\n", exitServerError, "", "monkey lsp: invalid Content-Length \"x\"\n"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+
+		code := run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+		if code != tt.expectedCode {
+			t.Errorf("run(%q) wrong exit code. expected=%d, got=%d", tt.args, tt.expectedCode, code)
+		}
+
+		if stdout.String() != tt.expectedStdout {
+			t.Errorf("run(%q) wrong stdout. expected=%q, got=%q", tt.args, tt.expectedStdout, stdout.String())
+		}
+
+		if stderr.String() != tt.expectedStderr {
+			t.Errorf("run(%q) wrong stderr. expected=%q, got=%q", tt.args, tt.expectedStderr, stderr.String())
+		}
+	}
+}
+
+func TestFmt(t *testing.T) {
+	dir := t.TempDir()
+	messy := filepath.Join(dir, "messy.mk")
+	tidy := filepath.Join(dir, "tidy.mk")
+	broken := filepath.Join(dir, "broken.mk")
+
+	files := map[string]string{
+		messy:  "var x=(1+2)*3 // nine\nputs( x )",
+		tidy:   "var x = 1;\n",
+		broken: "var = 2;",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(name, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		args           []string
+		stdin          string
+		expectedCode   int
+		expectedStdout string
+		expectedStderr string
+	}{
+		{[]string{"fmt"}, "if(x){1}", exitOK, "if (x) { 1 }\n", ""},
+		{[]string{"fmt", "-check"}, "x;\n", exitOK, "", ""},
+		{[]string{"fmt", "--check"}, "x", exitUnformatted, "<stdin>\n", ""},
+		{[]string{"fmt", messy}, "", exitOK, "var x = (1 + 2) * 3; // nine\nputs(x);\n", ""},
+		{[]string{"fmt", "-check", tidy, messy}, "", exitUnformatted, messy + "\n", ""},
+		{[]string{"fmt", broken}, "", exitParseError, "", broken + ":1:5: error: expected next token to be IDENT, got== instead\n"},
+		{[]string{"fmt", "-w"}, "x", exitUsage, "", "monkey fmt: -w needs files to write to\n"},
+		{[]string{"fmt", "-check", "-w", tidy}, "", exitUsage, "", "monkey fmt: -check and -w can't be used together\n"},
+		{[]string{"fmt", "-w", tidy, messy}, "", exitOK, "", ""},
+		{[]string{"fmt", "-check", tidy, messy}, "", exitOK, "", ""},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+
+		code := run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+		if code != tt.expectedCode {
+			t.Errorf("run(%q) wrong exit code. expected=%d, got=%d", tt.args, tt.expectedCode, code)
+		}
+
+		if stdout.String() != tt.expectedStdout {
+			t.Errorf("run(%q) wrong stdout. expected=%q, got=%q", tt.args, tt.expectedStdout, stdout.String())
+		}
+
+		if stderr.String() != tt.expectedStderr {
+			t.Errorf("run(%q) wrong stderr. expected=%q, got=%q", tt.args, tt.expectedStderr, stderr.String())
+		}
+	}
+
+	src, err := os.ReadFile(messy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "var x = (1 + 2) * 3; // nine\nputs(x);\n"
+	if string(src) != expected {
+		t.Errorf("fmt -w wrote wrong source. expected=%q, got=%q", expected, src)
+	}
+}
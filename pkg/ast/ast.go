@@ -12,6 +12,10 @@ type Node interface {
 	// used for debugging and testing.
 	TokenLiteral() string
 	String() string
+	// Pos returns the position of the first character that belongs to the node.
+	Pos() token.Position
+	// End returns the position immediately after the node.
+	End() token.Position
 }
 
 type Statement interface {
@@ -39,6 +43,22 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+
+	return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -61,6 +81,18 @@ func (v *VarStatement) TokenLiteral() string {
 	return v.Token.Literal
 }
 
+func (v *VarStatement) Pos() token.Position {
+	return v.Token.Pos
+}
+
+func (v *VarStatement) End() token.Position {
+	if v.Value != nil {
+		return v.Value.End()
+	}
+
+	return v.Name.End()
+}
+
 func (v *VarStatement) String() string {
 	var out bytes.Buffer
 
@@ -77,6 +109,146 @@ func (v *VarStatement) String() string {
 	return out.String()
 }
 
+// WhileStatement runs its body for as long as the condition is truthy.
+type WhileStatement struct {
+	Token     token.Token // the 'while' token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (s *WhileStatement) statementNode() {}
+
+func (s *WhileStatement) TokenLiteral() string {
+	return s.Token.Literal
+}
+
+func (s *WhileStatement) Pos() token.Position {
+	return s.Token.Pos
+}
+
+func (s *WhileStatement) End() token.Position {
+	if s.Body != nil {
+		return s.Body.End()
+	}
+
+	return s.Token.End
+}
+
+func (s *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while")
+	out.WriteString(s.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(s.Body.String())
+
+	return out.String()
+}
+
+// ForStatement runs its body once for every element of the iterable, with the
+// element bound to Variable.
+type ForStatement struct {
+	Token    token.Token // the 'for' token
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (s *ForStatement) statementNode() {}
+
+func (s *ForStatement) TokenLiteral() string {
+	return s.Token.Literal
+}
+
+func (s *ForStatement) Pos() token.Position {
+	return s.Token.Pos
+}
+
+func (s *ForStatement) End() token.Position {
+	if s.Body != nil {
+		return s.Body.End()
+	}
+
+	return s.Token.End
+}
+
+func (s *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for(")
+	out.WriteString(s.Variable.String())
+	out.WriteString(" in ")
+	out.WriteString(s.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(s.Body.String())
+
+	return out.String()
+}
+
+// BranchStatement is a 'break' or a 'continue' statement.
+type BranchStatement struct {
+	Token token.Token // the 'break' or 'continue' token
+}
+
+func (s *BranchStatement) statementNode() {}
+
+func (s *BranchStatement) TokenLiteral() string {
+	return s.Token.Literal
+}
+
+func (s *BranchStatement) Pos() token.Position {
+	return s.Token.Pos
+}
+
+func (s *BranchStatement) End() token.Position {
+	return s.Token.End
+}
+
+func (s *BranchStatement) String() string {
+	return s.Token.Literal + ";"
+}
+
+// AssignStatement stores a new value in an existing binding or in an element
+// of an array or hash. Compound operators like "+=" combine the current value
+// with the new one.
+type AssignStatement struct {
+	Token    token.Token // the assignment operator token
+	Target   Expression  // an *Identifier or an *IndexExpression
+	Operator string      // "=", "+=", "-=", "*=" or "/="
+	Value    Expression
+}
+
+func (s *AssignStatement) statementNode() {}
+
+func (s *AssignStatement) TokenLiteral() string {
+	return s.Token.Literal
+}
+
+func (s *AssignStatement) Pos() token.Position {
+	return s.Target.Pos()
+}
+
+func (s *AssignStatement) End() token.Position {
+	if s.Value != nil {
+		return s.Value.End()
+	}
+
+	return s.Token.End
+}
+
+func (s *AssignStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(s.Target.String())
+	out.WriteString(" " + s.Operator + " ")
+	if s.Value != nil {
+		out.WriteString(s.Value.String())
+	}
+	out.WriteString(";")
+
+	return out.String()
+}
+
 type Identifier struct {
 	Token token.Token // the token.IDENT token
 	Value string
@@ -88,6 +260,14 @@ func (i *Identifier) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+func (i *Identifier) Pos() token.Position {
+	return i.Token.Pos
+}
+
+func (i *Identifier) End() token.Position {
+	return i.Token.End
+}
+
 func (i *Identifier) String() string {
 	return i.Value
 }
@@ -103,6 +283,18 @@ func (s *ReturnStatement) TokenLiteral() string {
 	return s.Token.Literal
 }
 
+func (s *ReturnStatement) Pos() token.Position {
+	return s.Token.Pos
+}
+
+func (s *ReturnStatement) End() token.Position {
+	if s.ReturnValue != nil {
+		return s.ReturnValue.End()
+	}
+
+	return s.Token.End
+}
+
 func (s *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -127,6 +319,18 @@ func (e *ExpressionStatement) TokenLiteral() string {
 	return e.Token.Literal
 }
 
+func (e *ExpressionStatement) Pos() token.Position {
+	return e.Token.Pos
+}
+
+func (e *ExpressionStatement) End() token.Position {
+	if e.Expression != nil {
+		return e.Expression.End()
+	}
+
+	return e.Token.End
+}
+
 func (e *ExpressionStatement) String() string {
 	if e.Expression != nil {
 		return e.Expression.String()
@@ -146,10 +350,41 @@ func (i *IntegerLiteral) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+func (i *IntegerLiteral) Pos() token.Position {
+	return i.Token.Pos
+}
+
+func (i *IntegerLiteral) End() token.Position {
+	return i.Token.End
+}
+
 func (i *IntegerLiteral) String() string {
 	return i.Token.Literal
 }
 
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (f *FloatLiteral) expressionNode() {}
+
+func (f *FloatLiteral) TokenLiteral() string {
+	return f.Token.Literal
+}
+
+func (f *FloatLiteral) Pos() token.Position {
+	return f.Token.Pos
+}
+
+func (f *FloatLiteral) End() token.Position {
+	return f.Token.End
+}
+
+func (f *FloatLiteral) String() string {
+	return f.Token.Literal
+}
+
 type StringLiteral struct {
 	Token token.Token
 	Value string
@@ -161,6 +396,14 @@ func (s *StringLiteral) TokenLiteral() string {
 	return s.Token.Literal
 }
 
+func (s *StringLiteral) Pos() token.Position {
+	return s.Token.Pos
+}
+
+func (s *StringLiteral) End() token.Position {
+	return s.Token.End
+}
+
 func (s *StringLiteral) String() string {
 	return s.Token.Literal
 }
@@ -177,6 +420,18 @@ func (p *PrefixExpression) TokenLiteral() string {
 	return p.Token.Literal
 }
 
+func (p *PrefixExpression) Pos() token.Position {
+	return p.Token.Pos
+}
+
+func (p *PrefixExpression) End() token.Position {
+	if p.Right != nil {
+		return p.Right.End()
+	}
+
+	return p.Token.End
+}
+
 func (p *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -201,6 +456,22 @@ func (i *InfixExpression) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+func (i *InfixExpression) Pos() token.Position {
+	if i.Left != nil {
+		return i.Left.Pos()
+	}
+
+	return i.Token.Pos
+}
+
+func (i *InfixExpression) End() token.Position {
+	if i.Right != nil {
+		return i.Right.End()
+	}
+
+	return i.Token.End
+}
+
 func (i *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -224,6 +495,14 @@ func (b *Boolean) TokenLiteral() string {
 	return b.Token.Literal
 }
 
+func (b *Boolean) Pos() token.Position {
+	return b.Token.Pos
+}
+
+func (b *Boolean) End() token.Position {
+	return b.Token.End
+}
+
 func (b *Boolean) String() string {
 	return b.Token.Literal
 }
@@ -241,6 +520,22 @@ func (e *IfExpression) TokenLiteral() string {
 	return e.Token.Literal
 }
 
+func (e *IfExpression) Pos() token.Position {
+	return e.Token.Pos
+}
+
+func (e *IfExpression) End() token.Position {
+	if e.Alternative != nil {
+		return e.Alternative.End()
+	}
+
+	if e.Consequence != nil {
+		return e.Consequence.End()
+	}
+
+	return e.Token.End
+}
+
 func (e *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -260,6 +555,7 @@ func (e *IfExpression) String() string {
 type BlockStatement struct {
 	Token      token.Token // the { token
 	Statements []Statement
+	Rbrace     token.Position // end of the closing '}'
 }
 
 func (s *BlockStatement) statementNode() {}
@@ -268,6 +564,18 @@ func (s *BlockStatement) TokenLiteral() string {
 	return s.Token.Literal
 }
 
+func (s *BlockStatement) Pos() token.Position {
+	return s.Token.Pos
+}
+
+func (s *BlockStatement) End() token.Position {
+	if s.Rbrace.IsValid() {
+		return s.Rbrace
+	}
+
+	return s.Token.End
+}
+
 func (s *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -282,6 +590,7 @@ type FunctionLiteral struct {
 	Token      token.Token // the 'fn' token
 	Parameters []*Identifier
 	Body       *BlockStatement
+	Name       string // name of the binding the literal is assigned to, if any
 }
 
 func (f *FunctionLiteral) expressionNode() {}
@@ -290,6 +599,18 @@ func (f *FunctionLiteral) TokenLiteral() string {
 	return f.Token.Literal
 }
 
+func (f *FunctionLiteral) Pos() token.Position {
+	return f.Token.Pos
+}
+
+func (f *FunctionLiteral) End() token.Position {
+	if f.Body != nil {
+		return f.Body.End()
+	}
+
+	return f.Token.End
+}
+
 func (f *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -311,6 +632,7 @@ type CallExpression struct {
 	Token     token.Token // the '(' token
 	Func      Expression
 	Arguments []Expression
+	Rparen    token.Position // end of the closing ')'
 }
 
 func (c *CallExpression) expressionNode() {}
@@ -319,6 +641,22 @@ func (c *CallExpression) TokenLiteral() string {
 	return c.Token.Literal
 }
 
+func (c *CallExpression) Pos() token.Position {
+	if c.Func != nil {
+		return c.Func.Pos()
+	}
+
+	return c.Token.Pos
+}
+
+func (c *CallExpression) End() token.Position {
+	if c.Rparen.IsValid() {
+		return c.Rparen
+	}
+
+	return c.Token.End
+}
+
 func (c *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -338,6 +676,7 @@ func (c *CallExpression) String() string {
 type ArrayListeral struct {
 	Token    token.Token // the '[' token
 	Elements []Expression
+	Rbracket token.Position // end of the closing ']'
 }
 
 func (al *ArrayListeral) expressionNode() {}
@@ -346,6 +685,18 @@ func (al *ArrayListeral) TokenLiteral() string {
 	return al.Token.Literal
 }
 
+func (al *ArrayListeral) Pos() token.Position {
+	return al.Token.Pos
+}
+
+func (al *ArrayListeral) End() token.Position {
+	if al.Rbracket.IsValid() {
+		return al.Rbracket
+	}
+
+	return al.Token.End
+}
+
 func (al *ArrayListeral) String() string {
 	var out bytes.Buffer
 	elements := []string{}
@@ -358,5 +709,90 @@ func (al *ArrayListeral) String() string {
 	out.WriteString("]")
 
 	return out.String()
+}
+
+type IndexExpression struct {
+	Token    token.Token // the '[' token
+	Left     Expression
+	Index    Expression
+	Rbracket token.Position // end of the closing ']'
+}
+
+func (ie *IndexExpression) expressionNode() {}
+
+func (ie *IndexExpression) TokenLiteral() string {
+	return ie.Token.Literal
+}
+
+func (ie *IndexExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+
+	return ie.Token.Pos
+}
+
+func (ie *IndexExpression) End() token.Position {
+	if ie.Rbracket.IsValid() {
+		return ie.Rbracket
+	}
+
+	return ie.Token.End
+}
 
+func (ie *IndexExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(ie.Left.String())
+	out.WriteString("[")
+	out.WriteString(ie.Index.String())
+	out.WriteString("])")
+
+	return out.String()
+}
+
+// HashPair is a single key-value entry of a hash literal.
+type HashPair struct {
+	Key   Expression
+	Value Expression
+}
+
+type HashLiteral struct {
+	Token  token.Token    // the '{' token
+	Pairs  []HashPair     // pairs in source order
+	Rbrace token.Position // end of the closing '}'
+}
+
+func (hl *HashLiteral) expressionNode() {}
+
+func (hl *HashLiteral) TokenLiteral() string {
+	return hl.Token.Literal
+}
+
+func (hl *HashLiteral) Pos() token.Position {
+	return hl.Token.Pos
+}
+
+func (hl *HashLiteral) End() token.Position {
+	if hl.Rbrace.IsValid() {
+		return hl.Rbrace
+	}
+
+	return hl.Token.End
+}
+
+func (hl *HashLiteral) String() string {
+	var out bytes.Buffer
+
+	pairs := []string{}
+	for _, pair := range hl.Pairs {
+		pairs = append(pairs, pair.Key.String()+": "+pair.Value.String())
+	}
+
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+
+	return out.String()
 }
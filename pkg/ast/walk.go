@@ -0,0 +1,291 @@
+package ast
+
+import "fmt"
+
+// A Visitor's Visit method is called by Walk for every node. When it returns a
+// non-nil visitor w, Walk visits the children of the node with w and then
+// calls w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree rooted at node in depth-first order, visiting the
+// children of a node in source order. Missing children, as left by a parser
+// that reported errors, are skipped.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		walkStatements(v, n.Statements)
+
+	case *VarStatement:
+		if n.Name != nil {
+			Walk(v, n.Name)
+		}
+		walkExpression(v, n.Value)
+
+	case *AssignStatement:
+		walkExpression(v, n.Target)
+		walkExpression(v, n.Value)
+
+	case *ReturnStatement:
+		walkExpression(v, n.ReturnValue)
+
+	case *ExpressionStatement:
+		walkExpression(v, n.Expression)
+
+	case *WhileStatement:
+		walkExpression(v, n.Condition)
+		walkBlock(v, n.Body)
+
+	case *ForStatement:
+		if n.Variable != nil {
+			Walk(v, n.Variable)
+		}
+		walkExpression(v, n.Iterable)
+		walkBlock(v, n.Body)
+
+	case *BlockStatement:
+		walkStatements(v, n.Statements)
+
+	case *PrefixExpression:
+		walkExpression(v, n.Right)
+
+	case *InfixExpression:
+		walkExpression(v, n.Left)
+		walkExpression(v, n.Right)
+
+	case *IfExpression:
+		walkExpression(v, n.Condition)
+		walkBlock(v, n.Consequence)
+		walkBlock(v, n.Alternative)
+
+	case *FunctionLiteral:
+		for _, param := range n.Parameters {
+			Walk(v, param)
+		}
+		walkBlock(v, n.Body)
+
+	case *CallExpression:
+		walkExpression(v, n.Func)
+		walkExpressions(v, n.Arguments)
+
+	case *ArrayListeral:
+		walkExpressions(v, n.Elements)
+
+	case *IndexExpression:
+		walkExpression(v, n.Left)
+		walkExpression(v, n.Index)
+
+	case *HashLiteral:
+		for _, pair := range n.Pairs {
+			walkExpression(v, pair.Key)
+			walkExpression(v, pair.Value)
+		}
+
+	case *Identifier, *IntegerLiteral, *FloatLiteral, *StringLiteral, *Boolean, *BranchStatement:
+		// no children
+
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+
+	v.Visit(nil)
+}
+
+func walkStatements(v Visitor, stmts []Statement) {
+	for _, s := range stmts {
+		if s != nil {
+			Walk(v, s)
+		}
+	}
+}
+
+func walkExpressions(v Visitor, exprs []Expression) {
+	for _, e := range exprs {
+		walkExpression(v, e)
+	}
+}
+
+func walkExpression(v Visitor, e Expression) {
+	if e != nil {
+		Walk(v, e)
+	}
+}
+
+func walkBlock(v Visitor, b *BlockStatement) {
+	if b != nil {
+		Walk(v, b)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+
+	return nil
+}
+
+// Inspect traverses the tree rooted at node like Walk, calling f for every
+// node and then f(nil) once its children are done. The children of a node are
+// skipped when f returns false for it.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// ModifierFunc returns the node replacing node in the tree, which may be node
+// itself.
+type ModifierFunc func(node Node) Node
+
+// Modify rewrites the tree rooted at node bottom-up: the children of a node are
+// modified before the node is passed to modifier, and the node modifier
+// returns takes its place in the parent. Returning nil for a statement removes
+// it from its program or block. Modify panics when a replacement doesn't fit
+// its place, like an expression replacing a statement.
+func Modify(node Node, modifier ModifierFunc) Node {
+	switch n := node.(type) {
+	case *Program:
+		n.Statements = modifyStatements(n.Statements, modifier)
+
+	case *VarStatement:
+		n.Name = modifyIdentifier(n.Name, modifier)
+		n.Value = modifyExpression(n.Value, modifier)
+
+	case *AssignStatement:
+		n.Target = modifyExpression(n.Target, modifier)
+		n.Value = modifyExpression(n.Value, modifier)
+
+	case *ReturnStatement:
+		n.ReturnValue = modifyExpression(n.ReturnValue, modifier)
+
+	case *ExpressionStatement:
+		n.Expression = modifyExpression(n.Expression, modifier)
+
+	case *WhileStatement:
+		n.Condition = modifyExpression(n.Condition, modifier)
+		n.Body = modifyBlock(n.Body, modifier)
+
+	case *ForStatement:
+		n.Variable = modifyIdentifier(n.Variable, modifier)
+		n.Iterable = modifyExpression(n.Iterable, modifier)
+		n.Body = modifyBlock(n.Body, modifier)
+
+	case *BlockStatement:
+		n.Statements = modifyStatements(n.Statements, modifier)
+
+	case *PrefixExpression:
+		n.Right = modifyExpression(n.Right, modifier)
+
+	case *InfixExpression:
+		n.Left = modifyExpression(n.Left, modifier)
+		n.Right = modifyExpression(n.Right, modifier)
+
+	case *IfExpression:
+		n.Condition = modifyExpression(n.Condition, modifier)
+		n.Consequence = modifyBlock(n.Consequence, modifier)
+		n.Alternative = modifyBlock(n.Alternative, modifier)
+
+	case *FunctionLiteral:
+		for i, param := range n.Parameters {
+			n.Parameters[i] = modifyIdentifier(param, modifier)
+		}
+		n.Body = modifyBlock(n.Body, modifier)
+
+	case *CallExpression:
+		n.Func = modifyExpression(n.Func, modifier)
+		modifyExpressions(n.Arguments, modifier)
+
+	case *ArrayListeral:
+		modifyExpressions(n.Elements, modifier)
+
+	case *IndexExpression:
+		n.Left = modifyExpression(n.Left, modifier)
+		n.Index = modifyExpression(n.Index, modifier)
+
+	case *HashLiteral:
+		for i, pair := range n.Pairs {
+			n.Pairs[i] = HashPair{
+				Key:   modifyExpression(pair.Key, modifier),
+				Value: modifyExpression(pair.Value, modifier),
+			}
+		}
+
+	case *Identifier, *IntegerLiteral, *FloatLiteral, *StringLiteral, *Boolean, *BranchStatement:
+		// no children
+
+	default:
+		panic(fmt.Sprintf("ast.Modify: unexpected node type %T", n))
+	}
+
+	return modifier(node)
+}
+
+func modifyStatements(stmts []Statement, modifier ModifierFunc) []Statement {
+	modified := stmts[:0]
+	for _, s := range stmts {
+		if s == nil {
+			continue
+		}
+
+		switch m := Modify(s, modifier).(type) {
+		case nil:
+		case Statement:
+			modified = append(modified, m)
+		default:
+			panic(fmt.Sprintf("ast.Modify: %T can't replace the statement %T", m, s))
+		}
+	}
+
+	return modified
+}
+
+func modifyExpressions(exprs []Expression, modifier ModifierFunc) {
+	for i, e := range exprs {
+		exprs[i] = modifyExpression(e, modifier)
+	}
+}
+
+func modifyExpression(e Expression, modifier ModifierFunc) Expression {
+	if e == nil {
+		return nil
+	}
+
+	m, ok := Modify(e, modifier).(Expression)
+	if !ok {
+		panic(fmt.Sprintf("ast.Modify: the expression %T can't be replaced by a non-expression", e))
+	}
+
+	return m
+}
+
+func modifyBlock(b *BlockStatement, modifier ModifierFunc) *BlockStatement {
+	if b == nil {
+		return nil
+	}
+
+	m, ok := Modify(b, modifier).(*BlockStatement)
+	if !ok {
+		panic("ast.Modify: a block can only be replaced by a block")
+	}
+
+	return m
+}
+
+func modifyIdentifier(ident *Identifier, modifier ModifierFunc) *Identifier {
+	if ident == nil {
+		return nil
+	}
+
+	m, ok := Modify(ident, modifier).(*Identifier)
+	if !ok {
+		panic(fmt.Sprintf("ast.Modify: the identifier %s can only be replaced by an identifier", ident.Value))
+	}
+
+	return m
+}
@@ -0,0 +1,216 @@
+package ast
+
+import (
+	"fmt"
+	"monkey/pkg/token"
+	"reflect"
+	"testing"
+)
+
+func ident(name string) *Identifier {
+	return &Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}
+}
+
+func integer(value int64) *IntegerLiteral {
+	return &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: fmt.Sprint(value)}, Value: value}
+}
+
+var (
+	fnToken  = token.Token{Type: token.FUNC, Literal: "fn"}
+	varToken = token.Token{Type: token.VAR, Literal: "var"}
+)
+
+func block(stmts ...Statement) *BlockStatement {
+	return &BlockStatement{Statements: stmts}
+}
+
+// allNodes returns a program using every node type.
+func allNodes() *Program {
+	return &Program{Statements: []Statement{
+		&VarStatement{Name: ident("f"), Value: &FunctionLiteral{
+			Parameters: []*Identifier{ident("x")},
+			Body: block(&ReturnStatement{ReturnValue: &InfixExpression{
+				Left: ident("x"), Operator: "+", Right: &FloatLiteral{Value: 0.5},
+			}}),
+		}},
+		&AssignStatement{
+			Target:   &IndexExpression{Left: ident("xs"), Index: integer(0)},
+			Operator: "=",
+			Value:    &PrefixExpression{Operator: "-", Right: integer(1)},
+		},
+		&WhileStatement{Condition: &Boolean{Value: true}, Body: block(&BranchStatement{})},
+		&ForStatement{Variable: ident("v"), Iterable: &ArrayListeral{Elements: []Expression{integer(2)}}, Body: block()},
+		&ExpressionStatement{Expression: &IfExpression{
+			Condition:   &CallExpression{Func: ident("f"), Arguments: []Expression{integer(3)}},
+			Consequence: block(),
+			Alternative: block(&ExpressionStatement{Expression: &HashLiteral{Pairs: []HashPair{
+				{Key: &StringLiteral{Value: "k"}, Value: integer(4)},
+			}}}),
+		}},
+	}}
+}
+
+func TestInspect(t *testing.T) {
+	var visited []string
+	depth, maxDepth := 0, 0
+	Inspect(allNodes(), func(n Node) bool {
+		if n == nil {
+			depth--
+			return false
+		}
+
+		depth++
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+		visited = append(visited, fmt.Sprintf("%T", n))
+		return true
+	})
+
+	expected := []string{
+		"*ast.Program",
+		"*ast.VarStatement", "*ast.Identifier", "*ast.FunctionLiteral", "*ast.Identifier",
+		"*ast.BlockStatement", "*ast.ReturnStatement", "*ast.InfixExpression", "*ast.Identifier", "*ast.FloatLiteral",
+		"*ast.AssignStatement", "*ast.IndexExpression", "*ast.Identifier", "*ast.IntegerLiteral",
+		"*ast.PrefixExpression", "*ast.IntegerLiteral",
+		"*ast.WhileStatement", "*ast.Boolean", "*ast.BlockStatement", "*ast.BranchStatement",
+		"*ast.ForStatement", "*ast.Identifier", "*ast.ArrayListeral", "*ast.IntegerLiteral", "*ast.BlockStatement",
+		"*ast.ExpressionStatement", "*ast.IfExpression", "*ast.CallExpression", "*ast.Identifier", "*ast.IntegerLiteral",
+		"*ast.BlockStatement", "*ast.BlockStatement", "*ast.ExpressionStatement", "*ast.HashLiteral",
+		"*ast.StringLiteral", "*ast.IntegerLiteral",
+	}
+	if !reflect.DeepEqual(visited, expected) {
+		t.Errorf("wrong nodes visited.\nwant=%v\ngot= %v", expected, visited)
+	}
+
+	if depth != 0 {
+		t.Errorf("Visit(nil) not called once per node, depth is %d", depth)
+	}
+	if maxDepth != 7 {
+		t.Errorf("wrong maximum depth. want=7, got=%d", maxDepth)
+	}
+}
+
+func TestInspectSkipsChildren(t *testing.T) {
+	count := 0
+	Inspect(allNodes(), func(n Node) bool {
+		if n != nil {
+			count++
+		}
+		_, isStatement := n.(Statement)
+		return !isStatement
+	})
+
+	// the program and its five statements
+	if count != 6 {
+		t.Errorf("wrong number of nodes visited. want=6, got=%d", count)
+	}
+}
+
+func TestWalkSkipsMissingChildren(t *testing.T) {
+	program := &Program{Statements: []Statement{
+		&VarStatement{Name: ident("x")},
+		&ExpressionStatement{Expression: &IfExpression{Condition: ident("c"), Consequence: block()}},
+	}}
+
+	count := 0
+	Inspect(program, func(n Node) bool {
+		if n != nil {
+			count++
+		}
+		return true
+	})
+
+	if count != 7 {
+		t.Errorf("wrong number of nodes visited. want=7, got=%d", count)
+	}
+}
+
+func TestModify(t *testing.T) {
+	oneIntoTwo := func(node Node) Node {
+		if integer, ok := node.(*IntegerLiteral); ok && integer.Value == 1 {
+			integer.Value = 2
+			integer.Token.Literal = "2"
+		}
+		return node
+	}
+
+	tests := []struct {
+		input    Node
+		expected string
+	}{
+		{integer(1), "2"},
+		{&InfixExpression{Left: integer(1), Operator: "+", Right: integer(1)}, "(2 + 2)"},
+		{&PrefixExpression{Operator: "-", Right: integer(1)}, "(-2)"},
+		{&IndexExpression{Left: integer(1), Index: integer(1)}, "(2[2])"},
+		{&CallExpression{Func: ident("f"), Arguments: []Expression{integer(1), integer(3)}}, "f(2, 3)"},
+		{&ArrayListeral{Elements: []Expression{integer(1), integer(1)}}, "[2, 2]"},
+		{&HashLiteral{Pairs: []HashPair{{Key: integer(1), Value: integer(1)}}}, "{2: 2}"},
+		{&IfExpression{
+			Condition:   integer(1),
+			Consequence: block(&ExpressionStatement{Expression: integer(1)}),
+			Alternative: block(&ExpressionStatement{Expression: integer(1)}),
+		}, "if2 2else 2"},
+		{&FunctionLiteral{Token: fnToken, Body: block(&ReturnStatement{ReturnValue: integer(1)})}, "fn() 2;"},
+		{&VarStatement{Token: varToken, Name: ident("x"), Value: integer(1)}, "var x = 2;"},
+		{&AssignStatement{Target: ident("x"), Operator: "+=", Value: integer(1)}, "x += 2;"},
+		{&WhileStatement{Condition: integer(1), Body: block()}, "while2 "},
+		{&ForStatement{Variable: ident("x"), Iterable: integer(1), Body: block()}, "for(x in 2) "},
+		{&Program{Statements: []Statement{&ExpressionStatement{Expression: integer(1)}}}, "2"},
+	}
+
+	for _, tt := range tests {
+		modified := Modify(tt.input, oneIntoTwo)
+		if modified.String() != tt.expected {
+			t.Errorf("wrong result. want=%q, got=%q", tt.expected, modified.String())
+		}
+	}
+}
+
+func TestModifyReplacesAndRemovesNodes(t *testing.T) {
+	program := &Program{Statements: []Statement{
+		&ExpressionStatement{Expression: &InfixExpression{Left: ident("a"), Operator: "*", Right: integer(1)}},
+		&ExpressionStatement{Expression: ident("b")},
+		&ExpressionStatement{Expression: &FunctionLiteral{Token: fnToken, Body: block(
+			&ExpressionStatement{Expression: ident("b")},
+			&ExpressionStatement{Expression: ident("c")},
+		)}},
+	}}
+
+	modified := Modify(program, func(node Node) Node {
+		switch n := node.(type) {
+		case *InfixExpression:
+			// x * 1 is x
+			if right, ok := n.Right.(*IntegerLiteral); ok && n.Operator == "*" && right.Value == 1 {
+				return n.Left
+			}
+		case *ExpressionStatement:
+			if ident, ok := n.Expression.(*Identifier); ok && ident.Value == "b" {
+				return nil
+			}
+		}
+		return node
+	})
+
+	expected := "afn()c"
+	if modified.String() != expected {
+		t.Errorf("wrong result. want=%q, got=%q", expected, modified.String())
+	}
+}
+
+func TestModifyPanicsOnMisplacedNodes(t *testing.T) {
+	defer func() {
+		expected := "ast.Modify: the expression *ast.Identifier can't be replaced by a non-expression"
+		if r := recover(); r != expected {
+			t.Errorf("wrong panic. want=%q, got=%v", expected, r)
+		}
+	}()
+
+	statement := &ExpressionStatement{Expression: ident("x")}
+	Modify(statement, func(node Node) Node {
+		if _, ok := node.(*Identifier); ok {
+			return &BranchStatement{}
+		}
+		return node
+	})
+}
@@ -0,0 +1,776 @@
+package compiler
+
+import (
+	"fmt"
+	"monkey/pkg/ast"
+	"monkey/pkg/code"
+	"monkey/pkg/object"
+	"monkey/pkg/token"
+)
+
+// Bytecode is the output of the compiler: the instructions of the main
+// program and the constant pool they refer to.
+type Bytecode struct {
+	Instructions code.Instructions
+	SourceMap    code.SourceMap
+	Constants    []object.Object
+	GlobalNames  []string // the names of the global slots, by index
+}
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// CompilationScope holds the instructions of a function body while it is
+// being compiled.
+type CompilationScope struct {
+	instructions        code.Instructions
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+	loops               []*loop // loops enclosing the current instruction
+	sourceMap           code.SourceMap
+}
+
+// loop tracks the jumps of a loop being compiled.
+type loop struct {
+	start  int   // position 'continue' jumps to
+	breaks []int // positions of the OpJump of each 'break', patched at the end
+}
+
+type Compiler struct {
+	constants   []object.Object
+	symbolTable *SymbolTable
+	scopes      []CompilationScope
+	scopeIndex  int
+
+	pos token.Position // position of the innermost node being compiled
+	err error          // the first operand too large for its instruction
+}
+
+func New() *Compiler {
+	mainScope := CompilationScope{
+		instructions:        code.Instructions{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+	}
+
+	symbolTable := NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: symbolTable,
+		scopes:      []CompilationScope{mainScope},
+		scopeIndex:  0,
+	}
+}
+
+// NewWithState returns a compiler that keeps the globals and constants of a
+// previous compilation, which is what the REPL needs between inputs.
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.constants = constants
+	return compiler
+}
+
+// Compile lowers the given node to bytecode. The instructions emitted for a
+// node are mapped to its position, errors raised by the VM while executing
+// them point there.
+func (c *Compiler) Compile(node ast.Node) error {
+	if pos := node.Pos(); pos.IsValid() {
+		defer func(outer token.Position) { c.pos = outer }(c.pos)
+		c.pos = pos
+	}
+
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.VarStatement:
+		// the binding is defined after its value is compiled so that the
+		// value still sees a previous binding of the same name, unless the
+		// value is a function assigning to the binding
+		if fn, ok := node.Value.(*ast.FunctionLiteral); ok && assignsTo(fn, node.Name.Value) {
+			c.symbolTable.Define(node.Name.Value)
+		}
+
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+
+		c.storeSymbol(c.symbolTable.Define(node.Name.Value))
+
+	case *ast.AssignStatement:
+		return c.compileAssignStatement(node)
+
+	case *ast.WhileStatement:
+		l := c.enterLoop()
+
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+		exitPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		if err := c.Compile(node.Body); err != nil {
+			return err
+		}
+		c.emit(code.OpJump, l.start)
+
+		c.changeOperand(exitPos, len(c.currentInstructions()))
+		c.leaveLoop()
+
+	case *ast.ForStatement:
+		if err := c.Compile(node.Iterable); err != nil {
+			return err
+		}
+		c.emit(code.OpIter)
+
+		// the iterator lives in a hidden binding, the name can't clash with an
+		// identifier and is unique among nested loops
+		name := fmt.Sprintf("for.%d", len(c.scopes[c.scopeIndex].loops))
+		iterator := c.symbolTable.Define(name)
+		c.storeSymbol(iterator)
+
+		// like in the evaluator, the variable is only bound within the loop
+		// and doesn't overwrite a variable of the same name
+		variable, restore := c.symbolTable.shadow(node.Variable.Value)
+
+		l := c.enterLoop()
+		c.loadSymbol(iterator)
+		exitPos := c.emit(code.OpIterNext, 9999)
+		c.storeSymbol(variable)
+
+		err := c.Compile(node.Body)
+		restore()
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJump, l.start)
+
+		c.changeOperand(exitPos, len(c.currentInstructions()))
+		c.leaveLoop()
+
+	case *ast.BranchStatement:
+		loops := c.scopes[c.scopeIndex].loops
+		if len(loops) == 0 {
+			return c.errorf(node, "%s outside of a loop", node.Token.Literal)
+		}
+
+		l := loops[len(loops)-1]
+		// drop what the enclosing expressions pushed, like the first
+		// element of [i, if (c) { continue }]
+		c.emit(code.OpUnwindLoop)
+		if node.Token.Type == token.BREAK {
+			l.breaks = append(l.breaks, c.emit(code.OpJump, 9999))
+		} else {
+			c.emit(code.OpJump, l.start)
+		}
+
+	case *ast.ReturnStatement:
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.Identifier:
+		// like in the evaluator, a name the program hasn't defined yet is
+		// only an error if it is still undefined when evaluated, functions
+		// can refer to globals defined after them
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			symbol = c.symbolTable.declareGlobal(node.Value)
+		}
+		c.loadSymbol(symbol)
+
+	case *ast.IntegerLiteral:
+		integer := &object.Integer{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(integer))
+
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
+
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return c.errorf(node, "unknown operator %s", node.Operator)
+		}
+
+	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
+		}
+
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		switch node.Operator {
+		case "+":
+			c.emit(code.OpAdd)
+		case "-":
+			c.emit(code.OpSub)
+		case "*":
+			c.emit(code.OpMul)
+		case "/":
+			c.emit(code.OpDiv)
+		case ">":
+			c.emit(code.OpGreaterThan)
+		case "<":
+			c.emit(code.OpLessThan)
+		case ">=":
+			c.emit(code.OpGreaterEqual)
+		case "<=":
+			c.emit(code.OpLessEqual)
+		case "==":
+			c.emit(code.OpEqual)
+		case "!=":
+			c.emit(code.OpNotEqual)
+		default:
+			return c.errorf(node, "unknown operator %s", node.Operator)
+		}
+
+	case *ast.IfExpression:
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+
+		// emit an OpJumpNotTruthy with a bogus value, it's patched once the
+		// consequence has been compiled
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		if err := c.Compile(node.Consequence); err != nil {
+			return err
+		}
+		c.endBlockValue()
+
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+		if node.Alternative == nil {
+			c.emit(code.OpNull)
+		} else {
+			if err := c.Compile(node.Alternative); err != nil {
+				return err
+			}
+			c.endBlockValue()
+		}
+
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	case *ast.ArrayListeral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			if err := c.Compile(pair.Key); err != nil {
+				return err
+			}
+
+			if err := c.Compile(pair.Value); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(node.Pairs)*2)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+
+	case *ast.FunctionLiteral:
+		c.enterScope()
+
+		// a function assigning to its own name refers to the binding
+		// holding it, like in the evaluator, rather than to itself
+		if node.Name != "" && !assignsTo(node, node.Name) {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
+
+		for _, p := range node.Parameters {
+			c.symbolTable.Define(p.Value)
+		}
+
+		if err := c.Compile(node.Body); err != nil {
+			return err
+		}
+
+		if c.lastInstructionIs(code.OpPop) {
+			c.replaceLastPopWithReturn()
+		}
+
+		if !c.lastInstructionIs(code.OpReturnValue) {
+			c.emit(code.OpReturn)
+		}
+
+		freeSymbols := c.symbolTable.FreeSymbols
+		numLocals := c.symbolTable.numDefinitions
+		sourceMap := c.scopes[c.scopeIndex].sourceMap
+		instructions := c.leaveScope()
+
+		for _, s := range freeSymbols {
+			c.captureSymbol(s)
+		}
+
+		compiledFn := &object.CompiledFunction{
+			Instructions:  instructions,
+			SourceMap:     sourceMap,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Parameters),
+			Name:          node.Name,
+		}
+
+		fnIndex := c.addConstant(compiledFn)
+		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+
+	case *ast.CallExpression:
+		if err := c.Compile(node.Func); err != nil {
+			return err
+		}
+
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpCall, len(node.Arguments))
+
+	default:
+		return c.errorf(node, "unsupported node %T", node)
+	}
+
+	return c.err
+}
+
+// assignOpcodes maps the compound assignment operators to the operation they
+// apply.
+var assignOpcodes = map[string]code.Opcode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
+}
+
+func (c *Compiler) compileAssignStatement(node *ast.AssignStatement) error {
+	op, compound := assignOpcodes[node.Operator]
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(target.Value)
+		switch {
+		case !ok, symbol.Scope == BuiltinScope:
+			return c.errorf(node, "cannot assign to undeclared identifier: %s", target.Value)
+		}
+
+		if compound {
+			c.loadSymbol(symbol)
+		}
+
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+
+		if compound {
+			c.emit(op)
+		}
+		c.storeSymbol(symbol)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+
+		if err := c.Compile(target.Index); err != nil {
+			return err
+		}
+
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+
+		if compound {
+			c.emit(code.OpUpdateIndex, int(op))
+		} else {
+			c.emit(code.OpSetIndex)
+		}
+
+	default:
+		return c.errorf(node, "cannot assign to %s", node.Target.String())
+	}
+
+	return nil
+}
+
+// assignsTo reports whether the body of fn, or of a function nested in it,
+// assigns to name.
+func assignsTo(fn *ast.FunctionLiteral, name string) bool {
+	found := false
+	ast.Inspect(fn.Body, func(node ast.Node) bool {
+		if assign, ok := node.(*ast.AssignStatement); ok {
+			if ident, ok := assign.Target.(*ast.Identifier); ok && ident.Value == name {
+				found = true
+			}
+		}
+		return !found
+	})
+
+	return found
+}
+
+// compileLogicalExpression compiles "&&" and "||" so that the right operand is
+// skipped when the left one decides the result. The operand that decides it is
+// turned into a boolean with a double OpBang.
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	// the left operand was truthy
+	if node.Operator == "&&" {
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(code.OpBang)
+		c.emit(code.OpBang)
+	} else {
+		c.emit(code.OpTrue)
+	}
+
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	// the left operand was falsy
+	if node.Operator == "&&" {
+		c.emit(code.OpFalse)
+	} else {
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(code.OpBang)
+		c.emit(code.OpBang)
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	return nil
+}
+
+// Bytecode returns the result of the compilation.
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		SourceMap:    c.scopes[c.scopeIndex].sourceMap,
+		Constants:    c.constants,
+		GlobalNames:  c.globalSymbolTable().Names(),
+	}
+}
+
+func (c *Compiler) globalSymbolTable() *SymbolTable {
+	s := c.symbolTable
+	for s.Outer != nil {
+		s = s.Outer
+	}
+
+	return s
+}
+
+// SymbolTable returns the global symbol table, to be handed to NewWithState.
+func (c *Compiler) SymbolTable() *SymbolTable {
+	return c.symbolTable
+}
+
+// enterLoop starts a loop at the current position.
+func (c *Compiler) enterLoop() *loop {
+	c.emit(code.OpEnterLoop)
+	l := &loop{start: len(c.currentInstructions())}
+
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, l)
+
+	return l
+}
+
+// leaveLoop ends the innermost loop at the current position, where its breaks
+// jump to. Like in the evaluator, the loop itself evaluates to null.
+func (c *Compiler) leaveLoop() {
+	scope := &c.scopes[c.scopeIndex]
+	l := scope.loops[len(scope.loops)-1]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	for _, pos := range l.breaks {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+
+	c.emit(code.OpLeaveLoop)
+	c.emit(code.OpNull)
+	c.emit(code.OpPop)
+}
+
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// captureSymbol pushes the variable a closure being created refers to, so that
+// assignments made by the closure or its enclosing function are seen by both.
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
+// errorf returns an *object.Error raised at node, so that compilation errors
+// read like the runtime errors of the evaluator.
+func (c *Compiler) errorf(node ast.Node, format string, a ...interface{}) error {
+	return &object.Error{Message: fmt.Sprintf(format, a...), Pos: node.Pos()}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+// operandNames tells what the operands of the instructions count, for the
+// error raised when a program needs more than an operand can encode.
+var operandNames = map[code.Opcode][]string{
+	code.OpConstant:      {"constants"},
+	code.OpJumpNotTruthy: {"bytes of instructions"},
+	code.OpJump:          {"bytes of instructions"},
+	code.OpIterNext:      {"bytes of instructions"},
+	code.OpGetGlobal:     {"global variables"},
+	code.OpSetGlobal:     {"global variables"},
+	code.OpGetLocal:      {"local variables"},
+	code.OpSetLocal:      {"local variables"},
+	code.OpCaptureLocal:  {"local variables"},
+	code.OpGetFree:       {"free variables"},
+	code.OpSetFree:       {"free variables"},
+	code.OpCaptureFree:   {"free variables"},
+	code.OpArray:         {"array elements"},
+	code.OpHash:          {"hash keys and values"},
+	code.OpCall:          {"arguments"},
+	code.OpClosure:       {"constants", "free variables"},
+}
+
+// checkOperands records an error when an operand of op doesn't fit in its
+// width, code.Make would silently truncate it.
+func (c *Compiler) checkOperands(op code.Opcode, operands []int) {
+	def, err := code.Lookup(byte(op))
+	if err != nil || c.err != nil {
+		return
+	}
+
+	for i, operand := range operands {
+		limit := 1 << (8 * def.OperandWidths[i])
+		if operand >= limit {
+			name := "operands of " + def.Name
+			if names := operandNames[op]; i < len(names) {
+				name = names[i]
+			}
+			c.err = &object.Error{
+				Message: fmt.Sprintf("too many %s, the limit is %d", name, limit),
+				Pos:     c.pos,
+			}
+			return
+		}
+	}
+}
+
+// emit appends an instruction to the current scope and returns its position.
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands)
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+
+	c.setLastInstruction(op, pos)
+
+	return pos
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	updatedInstructions := append(c.currentInstructions(), ins...)
+
+	c.scopes[c.scopeIndex].instructions = updatedInstructions
+
+	scope := &c.scopes[c.scopeIndex]
+	if n := len(scope.sourceMap); n == 0 || scope.sourceMap[n-1].Pos != c.pos {
+		scope.sourceMap = append(scope.sourceMap, code.SourcePos{Offset: posNewInstruction, Pos: c.pos})
+	}
+
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	old := c.currentInstructions()
+	new := old[:last.Position]
+
+	c.scopes[c.scopeIndex].instructions = new
+	c.scopes[c.scopeIndex].lastInstruction = previous
+
+	sourceMap := c.scopes[c.scopeIndex].sourceMap
+	for len(sourceMap) > 0 && sourceMap[len(sourceMap)-1].Offset >= len(new) {
+		sourceMap = sourceMap[:len(sourceMap)-1]
+	}
+	c.scopes[c.scopeIndex].sourceMap = sourceMap
+}
+
+// endBlockValue makes sure a block used as an expression leaves exactly one
+// value on the stack: the value of its last expression statement, or null.
+func (c *Compiler) endBlockValue() {
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpNull)
+	}
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	c.checkOperands(op, []int{operand})
+	newInstruction := code.Make(op, operand)
+
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{
+		instructions:        code.Instructions{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+	}
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
@@ -0,0 +1,159 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// SymbolTable resolves identifiers to the scope and index the VM uses to
+// access them. Each function body gets its own table enclosed by the table of
+// the surrounding scope.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store          map[string]Symbol
+	numDefinitions int
+	names          []string // the name defined in each slot
+
+	// FreeSymbols holds the symbols of the enclosing scopes captured by the
+	// function being compiled, in the order the closure stores them.
+	FreeSymbols []Symbol
+}
+
+func NewSymbolTable() *SymbolTable {
+	s := make(map[string]Symbol)
+	free := []Symbol{}
+	return &SymbolTable{store: s, FreeSymbols: free}
+}
+
+// Copy returns a copy of the table, the REPL compiles against it so that a
+// failed compilation leaves the table as it was.
+func (s *SymbolTable) Copy() *SymbolTable {
+	c := &SymbolTable{
+		Outer:          s.Outer,
+		store:          make(map[string]Symbol, len(s.store)),
+		numDefinitions: s.numDefinitions,
+		names:          append([]string{}, s.names...),
+		FreeSymbols:    append([]Symbol{}, s.FreeSymbols...),
+	}
+	for name, symbol := range s.store {
+		c.store[name] = symbol
+	}
+
+	return c
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// Define binds name to the next free slot of the table. Redefining a name
+// reuses the slot it was given the first time.
+func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok &&
+		(symbol.Scope == GlobalScope || symbol.Scope == LocalScope) {
+		return symbol
+	}
+
+	symbol := Symbol{Name: name, Index: s.numDefinitions}
+	if s.Outer == nil {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+	}
+
+	s.store[name] = symbol
+	s.names = append(s.names, name)
+	s.numDefinitions++
+	return symbol
+}
+
+// Names returns the names defined in the slots of the table, by index.
+func (s *SymbolTable) Names() []string {
+	return s.names
+}
+
+// declareGlobal binds name to a slot of the outermost table, for a name that
+// is used before the program defines it. Reading the slot before it is set is
+// a runtime error.
+func (s *SymbolTable) declareGlobal(name string) Symbol {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+
+	return s.Define(name)
+}
+
+// shadow binds name to a new slot, even when the table already binds it,
+// until the returned function restores what name referred to before.
+func (s *SymbolTable) shadow(name string) (Symbol, func()) {
+	previous, ok := s.store[name]
+	delete(s.store, name)
+
+	symbol := s.Define(name)
+	return symbol, func() {
+		if ok {
+			s.store[name] = previous
+		} else {
+			delete(s.store, name)
+		}
+	}
+}
+
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineFunctionName binds the name of the function being compiled so that it
+// can refer to itself.
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1}
+	symbol.Scope = FreeScope
+
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+// Resolve looks name up in the table and its enclosing tables. Local
+// variables of enclosing functions are turned into free variables.
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	obj, ok := s.store[name]
+	if !ok && s.Outer != nil {
+		obj, ok = s.Outer.Resolve(name)
+		if !ok {
+			return obj, ok
+		}
+
+		if obj.Scope == GlobalScope || obj.Scope == BuiltinScope {
+			return obj, ok
+		}
+
+		free := s.defineFree(obj)
+		return free, true
+	}
+
+	return obj, ok
+}
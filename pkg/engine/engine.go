@@ -0,0 +1,207 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"monkey/pkg/ast"
+	"monkey/pkg/compiler"
+	"monkey/pkg/eval"
+	"monkey/pkg/object"
+	"monkey/pkg/vm"
+	"os"
+)
+
+// Names of the available engines, as accepted by New.
+const (
+	Eval = "eval"
+	VM   = "vm"
+)
+
+// Engine executes parsed programs. Global bindings persist between runs, so
+// an engine can back a REPL session.
+type Engine interface {
+	// Define binds name to val in the global scope.
+	Define(name string, val object.Object)
+	// SetStdout sets where the output of builtins like puts goes,
+	// os.Stdout by default.
+	SetStdout(w io.Writer)
+	// SetStderr sets where builtins report diagnostics, os.Stderr by
+	// default.
+	SetStderr(w io.Writer)
+	// Lookup returns the value bound to name in the global scope, including
+	// builtins.
+	Lookup(name string) (object.Object, bool)
+	// SetLimits sets the limits every run and call is held to.
+	SetLimits(limits object.Limits)
+	// Call calls fn, a function or a builtin produced by the engine, with
+	// args. A failing call returns an *object.Error. The call stops once
+	// ctx is done.
+	Call(ctx context.Context, fn object.Object, args []object.Object) object.Object
+	// Run executes the program and returns its value. Compilation and
+	// runtime failures are returned as *object.Error.
+	Run(program *ast.Program) object.Object
+	// RunContext is like Run but stops once ctx is done.
+	RunContext(ctx context.Context, program *ast.Program) object.Object
+}
+
+// New returns the engine with the given name.
+func New(name string) (Engine, error) {
+	switch name {
+	case Eval:
+		return NewEvaluator(), nil
+	case VM:
+		return NewVM(), nil
+	default:
+		return nil, fmt.Errorf("unknown engine %q", name)
+	}
+}
+
+type evaluator struct {
+	env *object.Env
+}
+
+// NewEvaluator returns an engine backed by the tree-walking evaluator.
+func NewEvaluator() Engine {
+	return &evaluator{env: object.NewEnv()}
+}
+
+func (e *evaluator) Define(name string, val object.Object) {
+	e.env.Set(name, val)
+}
+
+func (e *evaluator) SetStdout(w io.Writer) {
+	e.env.SetStdout(w)
+}
+
+func (e *evaluator) SetStderr(w io.Writer) {
+	e.env.SetStderr(w)
+}
+
+func (e *evaluator) Lookup(name string) (object.Object, bool) {
+	if val, ok := e.env.Get(name); ok {
+		return val, true
+	}
+
+	if builtin := object.GetBuiltinByName(name); builtin != nil {
+		return builtin, true
+	}
+
+	return nil, false
+}
+
+func (e *evaluator) SetLimits(limits object.Limits) {
+	e.env.SetLimits(limits)
+}
+
+func (e *evaluator) Call(ctx context.Context, fn object.Object, args []object.Object) object.Object {
+	e.env.Meter().Start(ctx)
+	return eval.Apply(fn, args, e.env)
+}
+
+func (e *evaluator) Run(program *ast.Program) object.Object {
+	return e.RunContext(context.Background(), program)
+}
+
+func (e *evaluator) RunContext(ctx context.Context, program *ast.Program) object.Object {
+	return eval.EvalContext(ctx, program, e.env)
+}
+
+type machine struct {
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+	stdout      io.Writer
+	stderr      io.Writer
+	limits      object.Limits
+}
+
+// NewVM returns an engine that compiles programs to bytecode and runs them on
+// the virtual machine.
+func NewVM() Engine {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	return &machine{
+		symbolTable: symbolTable,
+		constants:   []object.Object{},
+		globals:     make([]object.Object, vm.GlobalsSize),
+		stdout:      os.Stdout,
+		stderr:      os.Stderr,
+	}
+}
+
+func (m *machine) Define(name string, val object.Object) {
+	symbol := m.symbolTable.Define(name)
+	m.globals[symbol.Index] = val
+}
+
+func (m *machine) SetStdout(w io.Writer) {
+	m.stdout = w
+}
+
+func (m *machine) SetStderr(w io.Writer) {
+	m.stderr = w
+}
+
+func (m *machine) SetLimits(limits object.Limits) {
+	m.limits = limits
+}
+
+func (m *machine) Lookup(name string) (object.Object, bool) {
+	symbol, ok := m.symbolTable.Resolve(name)
+	if !ok {
+		return nil, false
+	}
+
+	switch symbol.Scope {
+	case compiler.GlobalScope:
+		val := m.globals[symbol.Index]
+		return val, val != nil
+	case compiler.BuiltinScope:
+		return object.Builtins[symbol.Index].Builtin, true
+	default:
+		return nil, false
+	}
+}
+
+// Call runs fn on a virtual machine sharing the globals and constants of the
+// engine, so closures compiled by earlier runs can be called.
+func (m *machine) Call(ctx context.Context, fn object.Object, args []object.Object) object.Object {
+	machine := m.newVM(&compiler.Bytecode{Constants: m.constants, GlobalNames: m.symbolTable.Names()})
+	return machine.CallContext(ctx, fn, args)
+}
+
+func (m *machine) newVM(bytecode *compiler.Bytecode) *vm.VM {
+	machine := vm.NewWithGlobalsStore(bytecode, m.globals)
+	machine.SetStdout(m.stdout)
+	machine.SetStderr(m.stderr)
+	machine.SetLimits(m.limits)
+	return machine
+}
+
+func (m *machine) Run(program *ast.Program) object.Object {
+	return m.RunContext(context.Background(), program)
+}
+
+func (m *machine) RunContext(ctx context.Context, program *ast.Program) object.Object {
+	// the names a failed compilation defines would never get a value
+	symbolTable := m.symbolTable.Copy()
+	comp := compiler.NewWithState(symbolTable, m.constants)
+	if err := comp.Compile(program); err != nil {
+		return object.ErrorFrom(err)
+	}
+	m.symbolTable = symbolTable
+
+	bytecode := comp.Bytecode()
+	m.constants = bytecode.Constants
+
+	machine := m.newVM(bytecode)
+	if err := machine.RunContext(ctx); err != nil {
+		return object.ErrorFrom(err)
+	}
+
+	return machine.LastPoppedStackElem()
+}
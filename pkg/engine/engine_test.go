@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"bytes"
+	"monkey/pkg/lexer"
+	"monkey/pkg/object"
+	"monkey/pkg/parser"
+	"testing"
+)
+
+func TestEnginesKeepGlobals(t *testing.T) {
+	inputs := []struct {
+		input    string
+		expected string
+	}{
+		{"var a = base * 2;", ""},
+		{"var double = fn(x) { x * 2 };", ""},
+		{"double(a) + base", "45"},
+		{"undefined", "identifier not found: undefined"},
+		{"var a = a + 1; a", "19"},
+	}
+
+	for _, name := range []string{Eval, VM} {
+		e, err := New(name)
+		if err != nil {
+			t.Fatalf("New(%q) returned error: %s", name, err)
+		}
+
+		e.Define("base", &object.Integer{Value: 9})
+
+		for _, tt := range inputs {
+			p := parser.New(lexer.New(tt.input))
+			program := p.ParseProgram()
+			if len(p.Errors()) != 0 {
+				t.Fatalf("parser errors: %v", p.Errors())
+			}
+
+			result := e.Run(program)
+			if tt.expected == "" {
+				continue
+			}
+
+			actual := result.Inspect()
+			if errObj, ok := result.(*object.Error); ok {
+				actual = errObj.Message
+			}
+
+			if actual != tt.expected {
+				t.Errorf("%s: wrong result for %q. want=%q, got=%q", name, tt.input, tt.expected, actual)
+			}
+		}
+	}
+}
+
+func TestVMForgetsFailedCompilations(t *testing.T) {
+	e, err := New(VM)
+	if err != nil {
+		t.Fatalf("New(%q) returned error: %s", VM, err)
+	}
+
+	if result := e.Run(parser.New(lexer.New("var x = 1; y = 2")).ParseProgram()); result.Type() != object.ERROR_OBJ {
+		t.Fatalf("expected a compilation error, got %s", result.Inspect())
+	}
+
+	result := e.Run(parser.New(lexer.New("x + 1")).ParseProgram())
+	errObj, ok := result.(*object.Error)
+	if !ok || errObj.Message != "identifier not found: x" {
+		t.Errorf("expected x to be undefined, got %s", result.Inspect())
+	}
+}
+
+func TestEnginesWriteToStdout(t *testing.T) {
+	for _, name := range []string{Eval, VM} {
+		e, err := New(name)
+		if err != nil {
+			t.Fatalf("New(%q) returned error: %s", name, err)
+		}
+
+		var out bytes.Buffer
+		e.SetStdout(&out)
+
+		for _, input := range []string{`puts("one")`, `printf("%d\n", 2)`} {
+			e.Run(parser.New(lexer.New(input)).ParseProgram())
+		}
+
+		if out.String() != "one\n2\n" {
+			t.Errorf("%s: wrong output. got=%q", name, out.String())
+		}
+	}
+}
+
+func TestUnknownEngine(t *testing.T) {
+	if _, err := New("jit"); err == nil {
+		t.Errorf("expected an error for an unknown engine")
+	}
+}
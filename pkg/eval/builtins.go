@@ -1,25 +1,35 @@
 package eval
 
-import "monkey/pkg/object"
-
-var builtins = map[string]*object.Builtin{
-	"len": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
-			}
-
-			switch arg := args[0].(type) {
-			case *object.String:
-				return &object.Integer{
-					Value: int64(len(arg.Value)),
-				}
-			default:
-				return newError(
-					"argument to `len` not supported, got %s",
-					args[0].Type(),
-				)
-			}
-		},
-	},
+import (
+	"io"
+	"monkey/pkg/object"
+)
+
+var builtins = map[string]*object.Builtin{}
+
+func init() {
+	for _, def := range object.Builtins {
+		builtins[def.Name] = def.Builtin
+	}
+}
+
+// runtime gives builtins access to the evaluator.
+type runtime struct {
+	env *object.Env
+}
+
+func (r runtime) Call(fn object.Object, args []object.Object) object.Object {
+	return applyFunction(fn, args, r.env)
+}
+
+func (r runtime) Stdout() io.Writer {
+	return r.env.Stdout()
+}
+
+func (r runtime) Stderr() io.Writer {
+	return r.env.Stderr()
+}
+
+func (r runtime) Charge(n int) *object.Error {
+	return r.env.Meter().Charge(n)
 }
@@ -1,18 +1,48 @@
 package eval
 
 import (
+	"context"
 	"fmt"
 	"monkey/pkg/ast"
 	"monkey/pkg/object"
+	"monkey/pkg/token"
+	"strings"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL     = &object.Null{}
+	TRUE     = &object.Boolean{Value: true}
+	FALSE    = &object.Boolean{Value: false}
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
+// EvalContext evaluates the given node within env like Eval, starting a new
+// run of the limits of env that stops once ctx is done.
+func EvalContext(ctx context.Context, node ast.Node, env *object.Env) object.Object {
+	env.Meter().Start(ctx)
+	return Eval(node, env)
+}
+
+// Eval evaluates the given node within env. Errors produced while evaluating
+// the node are tagged with the position of the innermost node that raised them.
+// Evaluation counts against the limits of env, see object.Env.SetLimits.
 func Eval(node ast.Node, env *object.Env) object.Object {
+	var result object.Object
+	if err := env.Meter().Step(); err != nil {
+		result = err
+	} else {
+		result = eval(node, env)
+	}
+
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+
+	return result
+}
+
+func eval(node ast.Node, env *object.Env) object.Object {
 	switch node := node.(type) {
 
 	// Statements
@@ -26,7 +56,7 @@ func Eval(node ast.Node, env *object.Env) object.Object {
 		return Eval(node.Expression, env)
 
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
+		val := evalTailExpression(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
@@ -39,10 +69,28 @@ func Eval(node ast.Node, env *object.Env) object.Object {
 		}
 		env.Set(node.Name.Value, val)
 
+	case *ast.AssignStatement:
+		return evalAssignStatement(node, env)
+
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+
+	case *ast.BranchStatement:
+		if node.Token.Type == token.BREAK {
+			return BREAK
+		}
+		return CONTINUE
+
 	// Expressions
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
 
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
+
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 
@@ -62,6 +110,10 @@ func Eval(node ast.Node, env *object.Env) object.Object {
 			return left
 		}
 
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node.Operator, left, node.Right, env)
+		}
+
 		right := Eval(node.Right, env)
 		if isError(right) {
 			return right
@@ -78,7 +130,7 @@ func Eval(node ast.Node, env *object.Env) object.Object {
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Func{Parameters: params, Env: env, Body: body}
+		return &object.Func{Parameters: params, Env: env, Body: body, Name: node.Name}
 
 	case *ast.CallExpression:
 		function := Eval(node.Func, env)
@@ -91,7 +143,31 @@ func Eval(node ast.Node, env *object.Env) object.Object {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		return applyCall(node, function, args, env)
+
+	case *ast.ArrayListeral:
+		elements := evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isError(elements[0]) {
+			return elements[0]
+		}
+
+		return &object.Array{Elements: elements}
+
+	case *ast.IndexExpression:
+		left := Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+
+		index := Eval(node.Index, env)
+		if isError(index) {
+			return index
+		}
+
+		return evalIndexExpression(left, index)
+
+	case *ast.HashLiteral:
+		return evalHashLiteral(node, env)
 	}
 
 	return nil
@@ -105,6 +181,10 @@ func evalProgram(program *ast.Program, env *object.Env) object.Object {
 
 		switch result := result.(type) {
 		case *object.ReturnVal:
+			if tc, ok := result.Value.(*tailCall); ok {
+				// the program returns a call, which still has to be made
+				return applyCall(tc.node, tc.fn, tc.args, env)
+			}
 			return result.Value
 		case *object.Error:
 			return result
@@ -124,8 +204,8 @@ func evalBlockStatement(
 		result = Eval(statement, env)
 
 		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VAL_OBJ || rt == object.ERROR_OBJ {
+			switch result.Type() {
+			case object.RETURN_VAL_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
 				return result
 			}
 		}
@@ -134,6 +214,169 @@ func evalBlockStatement(
 	return result
 }
 
+// evalAssignStatement updates the binding or the element targeted by the
+// assignment. Like a var statement it has no value of its own.
+func evalAssignStatement(node *ast.AssignStatement, env *object.Env) object.Object {
+	operator := strings.TrimSuffix(node.Operator, "=")
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		current, ok := env.Get(target.Value)
+		if !ok {
+			return newError("cannot assign to undeclared identifier: %s", target.Value)
+		}
+
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+
+		if operator != "" {
+			val = evalInfixExpression(operator, current, val)
+			if isError(val) {
+				return val
+			}
+		}
+
+		env.Assign(target.Value, val)
+
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isError(left) {
+			return left
+		}
+
+		index := Eval(target.Index, env)
+		if isError(index) {
+			return index
+		}
+
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+
+		if operator != "" {
+			if err := checkIndexAssignment(left, index); err != nil {
+				return err
+			}
+
+			val = evalInfixExpression(operator, evalIndexExpression(left, index), val)
+			if isError(val) {
+				return val
+			}
+		}
+
+		if err := evalIndexAssignment(left, index, val); err != nil {
+			return err
+		}
+
+	default:
+		return newError("cannot assign to %s", node.Target.String())
+	}
+
+	return nil
+}
+
+// checkIndexAssignment returns an error when left[index] can't be assigned to.
+func checkIndexAssignment(left, index object.Object) *object.Error {
+	switch left := left.(type) {
+	case *object.Array:
+		idx, ok := index.(*object.Integer)
+		if !ok {
+			return newError("index operator not supported: %s[%s]", left.Type(), index.Type())
+		}
+
+		if idx.Value < 0 || idx.Value >= int64(len(left.Elements)) {
+			return newError("index out of range: %d", idx.Value)
+		}
+	case *object.Hash:
+		if _, ok := index.(object.Hashable); !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+
+	return nil
+}
+
+// evalIndexAssignment sets left[index] to val. Arrays and hashes are updated
+// in place, so every binding referring to them sees the change.
+func evalIndexAssignment(left, index, val object.Object) *object.Error {
+	if err := checkIndexAssignment(left, index); err != nil {
+		return err
+	}
+
+	switch left := left.(type) {
+	case *object.Array:
+		left.Elements[index.(*object.Integer).Value] = val
+	case *object.Hash:
+		key := index.(object.Hashable).HashKey()
+		left.Pairs[key] = object.HashPair{Key: index, Value: val}
+	}
+
+	return nil
+}
+
+// evalWhileStatement runs the body of the loop until its condition becomes
+// falsy or the body breaks out of it. Loops evaluate to null.
+func evalWhileStatement(loop *ast.WhileStatement, env *object.Env) object.Object {
+	for {
+		condition := Eval(loop.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+
+		if !isTruthy(condition) {
+			return NULL
+		}
+
+		if result, done := evalLoopBody(loop.Body, env); done {
+			return result
+		}
+	}
+}
+
+// evalForStatement runs the body of the loop once for each element of the
+// iterable, see object.Iterate. The variable of the loop is only bound within
+// it.
+func evalForStatement(loop *ast.ForStatement, env *object.Env) object.Object {
+	iterable := Eval(loop.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	elements, ok := object.Iterate(iterable)
+	if !ok {
+		return newError("not iterable: %s", iterable.Type())
+	}
+
+	loopEnv := object.NewLoopEnv(env, loop.Variable.Value)
+	for _, element := range elements {
+		loopEnv.Set(loop.Variable.Value, element)
+
+		if result, done := evalLoopBody(loop.Body, loopEnv); done {
+			return result
+		}
+	}
+
+	return NULL
+}
+
+// evalLoopBody runs one iteration of a loop. It reports whether the loop is
+// over, along with the value the loop evaluates to in that case.
+func evalLoopBody(body *ast.BlockStatement, env *object.Env) (object.Object, bool) {
+	switch result := Eval(body, env).(type) {
+	case *object.Break:
+		return NULL, true
+	case *object.ReturnVal, *object.Error:
+		return result, true
+	default:
+		return nil, false
+	}
+}
+
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	if input {
 		return TRUE
@@ -159,12 +402,17 @@ func evalInfixExpression(
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
+	case left.Type() == object.ARRAY_OBJ && right.Type() == object.ARRAY_OBJ,
+		left.Type() == object.HASH_OBJ && right.Type() == object.HASH_OBJ:
+		return evalCollectionInfixExpression(operator, left, right)
 	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(object.Equal(left, right))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s",
 			left.Type(), operator, right.Type())
@@ -174,26 +422,40 @@ func evalInfixExpression(
 	}
 }
 
-func evalBangOperatorExpression(right object.Object) object.Object {
-	switch right {
-	case TRUE:
-		return FALSE
-	case FALSE:
-		return TRUE
-	case NULL:
-		return TRUE
-	default:
-		return FALSE
+// evalLogicalExpression evaluates "&&" and "||" given the value of the left
+// operand. The right operand is only evaluated when the left one doesn't
+// decide the result. Both operators produce a boolean.
+func evalLogicalExpression(
+	operator string,
+	left object.Object,
+	right ast.Expression,
+	env *object.Env,
+) object.Object {
+	if isTruthy(left) == (operator == "||") {
+		return nativeBoolToBooleanObject(isTruthy(left))
 	}
+
+	value := Eval(right, env)
+	if isError(value) {
+		return value
+	}
+
+	return nativeBoolToBooleanObject(isTruthy(value))
+}
+
+func evalBangOperatorExpression(right object.Object) object.Object {
+	return nativeBoolToBooleanObject(!isTruthy(right))
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -right.Value}
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
 func evalIntegerInfixExpression(
@@ -211,11 +473,54 @@ func evalIntegerInfixExpression(
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
 		return &object.Integer{Value: leftVal / rightVal}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
+
+// evalFloatInfixExpression evaluates an operation between two numbers where at
+// least one of them is a float. Integers are promoted to floats.
+func evalFloatInfixExpression(
+	operator string,
+	left, right object.Object,
+) object.Object {
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -226,6 +531,21 @@ func evalIntegerInfixExpression(
 	}
 }
 
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
 func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
 	if operator != "+" {
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
@@ -239,6 +559,102 @@ func evalStringInfixExpression(operator string, left, right object.Object) objec
 	}
 }
 
+func evalCollectionInfixExpression(operator string, left, right object.Object) object.Object {
+	switch operator {
+	case "==":
+		return nativeBoolToBooleanObject(object.Equal(left, right))
+	case "!=":
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func evalIndexExpression(left, index object.Object) object.Object {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(left, index)
+	case left.Type() == object.HASH_OBJ:
+		return evalHashIndexExpression(left, index)
+	default:
+		return newError("index operator not supported: %s[%s]", left.Type(), index.Type())
+	}
+}
+
+// evalArrayIndexExpression returns the element at the given index, or NULL when
+// the index falls outside of the array.
+func evalArrayIndexExpression(array, index object.Object) object.Object {
+	elements := array.(*object.Array).Elements
+	idx := index.(*object.Integer).Value
+	max := int64(len(elements) - 1)
+
+	if idx < 0 || idx > max {
+		return NULL
+	}
+
+	return elements[idx]
+}
+
+// evalStringIndexExpression returns the character at the given index as a new
+// string, or NULL when the index falls outside of the string. Strings are
+// indexed by character, not by byte.
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	char, ok := str.(*object.String).CharAt(index.(*object.Integer).Value)
+	if !ok {
+		return NULL
+	}
+
+	return &object.String{Value: char}
+}
+
+// evalHashIndexExpression looks up the given key in the hash, returning NULL
+// when it is not present.
+func evalHashIndexExpression(hash, index object.Object) object.Object {
+	hashObject := hash.(*object.Hash)
+
+	key, ok := index.(object.Hashable)
+	if !ok {
+		return newError("unusable as hash key: %s", index.Type())
+	}
+
+	pair, ok := hashObject.Pairs[key.HashKey()]
+	if !ok {
+		return NULL
+	}
+
+	return pair.Value
+}
+
+func evalHashLiteral(
+	node *ast.HashLiteral,
+	env *object.Env,
+) object.Object {
+	pairs := make(map[object.HashKey]object.HashPair)
+
+	for _, pair := range node.Pairs {
+		key := Eval(pair.Key, env)
+		if isError(key) {
+			return key
+		}
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", key.Type())
+		}
+
+		value := Eval(pair.Value, env)
+		if isError(value) {
+			return value
+		}
+
+		pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+
+	return &object.Hash{Pairs: pairs}
+}
+
 func evalIfExpression(
 	ie *ast.IfExpression,
 	env *object.Env,
@@ -272,13 +688,13 @@ func evalIdentifier(
 	return newError("identifier not found: " + node.Value)
 }
 
+// isTruthy checks booleans by value rather than against TRUE and FALSE, as
+// the host of an interpreter may create its own.
 func isTruthy(obj object.Object) bool {
-	switch obj {
-	case NULL:
-		return false
-	case TRUE:
-		return true
-	case FALSE:
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
 		return false
 	default:
 		return true
@@ -313,19 +729,110 @@ func evalExpressions(
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// Apply calls fn, a function or a builtin, with args and returns the result,
+// which is an *object.Error when the call fails. Builtins write their output
+// to the writers of env.
+func Apply(fn object.Object, args []object.Object, env *object.Env) object.Object {
+	return applyFunction(fn, args, env)
+}
+
+// applyCall applies fn to args for the call expression node and records the
+// call in the stack trace of the error it may produce.
+func applyCall(
+	node *ast.CallExpression,
+	fn object.Object,
+	args []object.Object,
+	env *object.Env,
+) object.Object {
+	result := applyFunction(fn, args, env)
+	if err, ok := result.(*object.Error); ok {
+		err.Stack = append(err.Stack, stackFrame(node, fn, args))
+	}
+
+	return result
+}
+
+func stackFrame(node *ast.CallExpression, fn object.Object, args []object.Object) object.StackFrame {
+	return object.StackFrame{
+		Function: functionName(node, fn),
+		Pos:      node.Pos(),
+		Args:     object.SummarizeArgs(args),
+	}
+}
+
+// applyFunction calls fn with args. Builtins run with the output of env, the
+// environment of the call. The calls that functions make in tail position
+// are run one after the other here rather than recursively, so that the
+// stack doesn't grow with them.
+func applyFunction(fn object.Object, args []object.Object, env *object.Env) object.Object {
+	meter := env.Meter()
+	if err := meter.Enter(); err != nil {
+		return err
+	}
+	defer meter.Leave()
+
+	var tailCalls []*tailCall
+	for {
+		result := invoke(fn, args, env)
+
+		tc, ok := result.(*tailCall)
+		if !ok {
+			if err, ok := result.(*object.Error); ok {
+				addTailFrames(err, tailCalls)
+			}
+			return result
+		}
+
+		if len(tailCalls) == maxTailFrames {
+			tailCalls = tailCalls[1:]
+		}
+		tailCalls = append(tailCalls, tc)
+
+		fn, args = tc.fn, tc.args
+	}
+}
+
+// invoke makes a single call of fn, returning a *tailCall when fn ends with
+// another call.
+func invoke(fn object.Object, args []object.Object, env *object.Env) object.Object {
 	switch fn := fn.(type) {
 	case *object.Func:
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d",
+				len(fn.Parameters), len(args))
+		}
+
 		extendedEnv := extendFunctionEnv(fn, args)
-		evaluted := Eval(fn.Body, extendedEnv)
+		evaluted := evalTailBlock(fn.Body, extendedEnv)
+		if evaluted == nil {
+			// the body ended with a statement that has no value
+			return NULL
+		}
 		return unwrapReturnValue(evaluted)
 	case *object.Builtin:
-		return fn.Fn(args...)
+		if result := fn.Fn(runtime{env}, args...); result != nil {
+			return result
+		}
+		return NULL
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
+// functionName returns the name used for the called function in stack
+// traces.
+func functionName(call *ast.CallExpression, fn object.Object) string {
+	if f, ok := fn.(*object.Func); ok && f.Name != "" {
+		return f.Name
+	}
+
+	if ident, ok := call.Func.(*ast.Identifier); ok {
+		return ident.Value
+	}
+
+	return "<anonymous>"
+}
+
 func extendFunctionEnv(
 	fn *object.Func,
 	args []object.Object,
@@ -1,6 +1,10 @@
 package eval
 
 import (
+	"bytes"
+	"context"
+	"math"
+	"monkey/internal/evaltest"
 	"monkey/pkg/lexer"
 	"monkey/pkg/object"
 	"monkey/pkg/parser"
@@ -8,100 +12,71 @@ import (
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected int64
-	}{
-		{"5", 5},
-		{"10", 10},
-		{"-5", -5},
-		{"-10", -10},
-		{"5 + 5 + 5 + 5 - 10", 10},
-		{"2 * 2 * 2 * 2 * 2", 32},
-		{"-50 + 100 + -50", 0},
-		{"5 * 2 + 10", 20},
-		{"5 + 2 * 10", 25},
-		{"20 + 2 * -10", 0},
-		{"50 / 2 * 2 + 10", 60},
-		{"2 * (5 + 10)", 30},
-		{"3 * 3 * 3 + 10", 37},
-		{"3 * (3 * 3) + 10", 37},
-		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+	for _, tt := range evaltest.Integers {
+		evaluated := testEval(tt.Input)
+		testIntegerObject(t, evaluated, tt.Expected)
 	}
+}
 
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		testIntegerObject(t, evaluated, tt.expected)
+func TestEvalFloatExpression(t *testing.T) {
+	for _, tt := range evaltest.Floats {
+		evaluated := testEval(tt.Input)
+		testFloatObject(t, evaluated, tt.Expected)
 	}
 }
 
-func TestEvalBooleanExpression(t *testing.T) {
+func TestFloatInspect(t *testing.T) {
 	tests := []struct {
 		input    string
-		expected bool
+		expected string
 	}{
-		{"true", true},
-		{"false", false},
-		{"1 < 2", true},
-		{"1 > 2", false},
-		{"1 < 1", false},
-		{"1 > 1", false},
-		{"1 == 1", true},
-		{"1 != 1", false},
-		{"1 == 2", false},
-		{"1 != 2", true},
-		{"true == true", true},
-		{"false == false", true},
-		{"true == false", false},
-		{"true != false", true},
-		{"false != true", true},
-		{"(1 < 2) == true", true},
-		{"(1 > 2) == true", false},
-		{"(1 > 2) == false", true},
+		{"3.14", "3.14"},
+		{"1.5 + 1.5", "3.0"},
+		{"1e21 * 1.0", "1e+21"},
+		{"0.1 + 0.2", "0.30000000000000004"},
+		{"-0.000001 / 10", "-1e-07"},
+		{"1e308 * 10", "1.0 / 0.0"},
+		{"-1.0 / 0.0", "-1.0 / 0.0"},
+		{"0.0 / 0.0", "0.0 / 0.0"},
 	}
 
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
-		testBooleanObject(t, evaluated, tt.expected)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong inspect output for %q. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+
+		// the output must read back as the same float, NaN being the one
+		// float unequal to itself
+		value := evaluated.(*object.Float).Value
+		if math.IsNaN(value) {
+			if readBack := testEval(evaluated.Inspect()); readBack.Inspect() != tt.expected {
+				t.Errorf("%q reads back as %q", tt.expected, readBack.Inspect())
+			}
+			continue
+		}
+		testFloatObject(t, testEval(evaluated.Inspect()), value)
 	}
 }
 
-func TestBangOperator(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected bool
-	}{
-		{"!true", false},
-		{"!false", true},
-		{"!5", false},
-		{"!!true", true},
-		{"!!false", false},
-		{"!!5", true},
+func TestEvalBooleanExpression(t *testing.T) {
+	for _, tt := range evaltest.Booleans {
+		evaluated := testEval(tt.Input)
+		testBooleanObject(t, evaluated, tt.Expected)
 	}
+}
 
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		testBooleanObject(t, evaluated, tt.expected)
+func TestBangOperator(t *testing.T) {
+	for _, tt := range evaltest.BangOperators {
+		evaluated := testEval(tt.Input)
+		testBooleanObject(t, evaluated, tt.Expected)
 	}
 }
 
 func TestIfElseExpressions(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected interface{}
-	}{
-		{"if (true) { 10 }", 10},
-		{"if (false) { 10 }", nil},
-		{"if (1) { 10 }", 10},
-		{"if (1 < 2) { 10 }", 10},
-		{"if (1 > 2) { 10 }", nil},
-		{"if (1 > 2) { 10 } else { 20 }", 20},
-		{"if (1 < 2) { 10 } else { 20 }", 10},
-	}
-
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		integ, ok := tt.expected.(int)
+	for _, tt := range evaltest.IfElseExpressions {
+		evaluated := testEval(tt.Input)
+		integ, ok := tt.Expected.(int)
 		if ok {
 			testIntegerObject(t, evaluated, int64(integ))
 		} else {
@@ -111,51 +86,90 @@ func TestIfElseExpressions(t *testing.T) {
 }
 
 func TestReturnStatements(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected int64
-	}{
-		{"return 10;", 10},
-		{"return 10; 9", 10},
-		{"return 2 * 5; 9", 10},
-		{"9; return 2 * 5; 9", 10},
-		{`if (10 > 1) {
-			if (10 > 1) {
-				return 10;
-			}
+	for _, tt := range evaltest.ReturnStatements {
+		evaluated := testEval(tt.Input)
+		testIntegerObject(t, evaluated, tt.Expected)
+	}
+}
 
-			return 1;
+func TestErrorHandling(t *testing.T) {
+	for _, tt := range evaltest.Errors {
+		evaluated := testEval(tt.Input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned: got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.Expected {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.Expected, errObj.Message)
 		}
-		`, 10},
 	}
+}
 
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		testIntegerObject(t, evaluated, tt.expected)
+func TestErrorPositions(t *testing.T) {
+	for _, tt := range evaltest.ErrorPositions {
+		evaluated := testEval(tt.Input)
+		if evaluated.Inspect() != tt.Expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.Expected, evaluated.Inspect())
+		}
 	}
 }
 
-func TestErrorHandling(t *testing.T) {
+func TestErrorStackTraces(t *testing.T) {
+	input := `var divide = fn(a, b) { a / b };
+var average = fn(values, count) {
+	divide(values[0] + values[1], count)
+};
+average([1, 2], 0)`
+
+	evaluated := testEval(input)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned: got=%T(%+v)", evaluated, evaluated)
+	}
+
+	expected := `Traceback (most recent call last):
+  5:1, in <main>: average([1, 2], 0)
+  3:2, in average: divide(3, 0)
+`
+	if errObj.Traceback() != expected {
+		t.Errorf("wrong traceback.\nwant=%q\ngot =%q", expected, errObj.Traceback())
+	}
+
+	if errObj.Inspect() != "error: 1:25: division by zero" {
+		t.Errorf("wrong error. got=%q", errObj.Inspect())
+	}
+}
+
+func TestErrorStackFrames(t *testing.T) {
 	tests := []struct {
-		input           string
-		expectedMessage string
+		input    string
+		expected []object.StackFrame
 	}{
-		{"5 + true", "type mismatch: INTEGER + BOOLEAN"},
-		{"5 + true; 5;", "type mismatch: INTEGER + BOOLEAN"},
-		{"-true", "unknown operator: -BOOLEAN"},
-		{"true + false", "unknown operator: BOOLEAN + BOOLEAN"},
-		{"5; true + false; 5", "unknown operator: BOOLEAN + BOOLEAN"},
-		{"if (10 > 1) { true + false; }", "unknown operator: BOOLEAN + BOOLEAN"},
+		{"1 / 0", nil},
 		{
-			`if (10 > 1) {
-				if (10 > 1) {
-					return true + false;
-				}
-			return 1;
-			}`,
-			"unknown operator: BOOLEAN + BOOLEAN"},
-		{"foobar", "identifier not found: foobar"},
-		{`"Hello" - "World"`, "unknown operator: STRING - STRING"},
+			`len(1, "a long string that will be cut")`,
+			[]object.StackFrame{{Function: "len", Args: `1, "a long string th...`}},
+		},
+		{
+			`len("ééééééééééééééééééééé", 1)`,
+			[]object.StackFrame{{Function: "len", Args: `"éééééééééééééééé..., 1`}},
+		},
+		{
+			"fn(f) { f(1) }(fn(x) { -true })",
+			[]object.StackFrame{
+				{Function: "f", Args: "1"},
+				{Function: "<anonymous>", Args: "fn"},
+			},
+		},
+		{
+			"var inc = fn(x) { x + true }; var apply = fn(f, x) { f(x) }; apply(inc, 1)",
+			[]object.StackFrame{
+				{Function: "inc", Args: "1"},
+				{Function: "apply", Args: "fn inc, 1"},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -166,25 +180,23 @@ func TestErrorHandling(t *testing.T) {
 			continue
 		}
 
-		if errObj.Message != tt.expectedMessage {
-			t.Errorf("wrong error message. expected=%q, got=%q", tt.expectedMessage, errObj.Message)
+		if len(errObj.Stack) != len(tt.expected) {
+			t.Errorf("wrong stack size for %q. want=%d, got=%d", tt.input, len(tt.expected), len(errObj.Stack))
+			continue
+		}
+
+		for i, frame := range tt.expected {
+			actual := errObj.Stack[i]
+			if actual.Function != frame.Function || actual.Args != frame.Args {
+				t.Errorf("wrong frame %d for %q. want=%+v, got=%+v", i, tt.input, frame, actual)
+			}
 		}
 	}
 }
 
 func TestVarStatement(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected int64
-	}{
-		{"var a = 5; a;", 5},
-		{"var a = 5 * 5; a;", 25},
-		{"var a = 5; var b = a; b;", 5},
-		{"var a = 5; var b = a; var c = a + b + 5; c;", 15},
-	}
-
-	for _, tt := range tests {
-		testIntegerObject(t, testEval(tt.input), tt.expected)
+	for _, tt := range evaltest.VarStatements {
+		testIntegerObject(t, testEval(tt.Input), tt.Expected)
 	}
 }
 
@@ -211,20 +223,8 @@ func TestFunctionObject(t *testing.T) {
 }
 
 func TestFunctionApplication(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected int64
-	}{
-		{"var identity = fn(x) { x; }; identity(5);", 5},
-		{"var identity = fn(x) { return x; }; identity(5);", 5},
-		{"var double = fn(x) { x * 2; }; double(5);", 10},
-		{"var add = fn(x, y) { x + y; }; add(5, 5);", 10},
-		{"var add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
-		{"fn(x) { x; }(5)", 5},
-	}
-
-	for _, tt := range tests {
-		testIntegerObject(t, testEval(tt.input), tt.expected)
+	for _, tt := range evaltest.FunctionApplications {
+		testIntegerObject(t, testEval(tt.Input), tt.Expected)
 	}
 }
 
@@ -270,15 +270,163 @@ func TestStringConcatenation(t *testing.T) {
 }
 
 func TestBuiltinFunctions(t *testing.T) {
+	for _, tt := range evaltest.Builtins {
+		evaluated := testEval(tt.Input)
+
+		switch expected := tt.Expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestCollectionBuiltins(t *testing.T) {
+	for _, tt := range evaltest.CollectionBuiltins {
+		evaluated := testEval(tt.Input)
+
+		actual := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			actual = errObj.Message
+		}
+
+		if actual != tt.Expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.Input, tt.Expected, actual)
+		}
+	}
+}
+
+func TestOutputBuiltins(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+		result string // inspected result or error message
+	}{
+		{`puts("a", 1, [true])`, "a\n1\n[true]\n", "null"},
+		{"puts()", "", "null"},
+		{`print("a", 1); print("b")`, "a 1b", "null"},
+		{`printf("%s is %d, %5.2f%%\n", "pi", 3, 3.14159)`, "pi is 3,  3.14%\n", "null"},
+		{`printf("%q %v %t %-3d|", "a\"b", {1: 2}, false, 7)`, `"a\"b" {1: 2} false 7  |`, "null"},
+		{`format("%d-%f", 1, 2)`, "", "1-2.000000"},
+		{`format("%d", "a")`, "", "wrong argument for %d, got STRING"},
+		{`format("%d %d", 1)`, "", "missing argument for %d in `format` format"},
+		{`format("%d", 1, 2)`, "", "`format` got 2 arguments for 1 verbs"},
+		{`format("%x", 1)`, "", "unknown verb %x"},
+		{`format("100%")`, "", "`format` format ends with an incomplete verb %"},
+		{"printf(1)", "", "format of `printf` must be STRING, got INTEGER"},
+		{"var f = fn(x) { puts(x) }; map([1, 2], f)", "1\n2\n", "[null, null]"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+		var out bytes.Buffer
+		env := object.NewEnv()
+		env.SetStdout(&out)
+
+		evaluated := Eval(program, env)
+
+		if out.String() != tt.output {
+			t.Errorf("wrong output for %q. want=%q, got=%q", tt.input, tt.output, out.String())
+		}
+
+		actual := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			actual = errObj.Message
+		}
+
+		if actual != tt.result {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.result, actual)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	countdown := "var f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } };"
+
+	tests := []struct {
+		input    string
+		limits   object.Limits
+		ctx      context.Context
+		expected object.ErrorKind
+		message  string
+	}{
+		{"while (true) { }", object.Limits{MaxSteps: 1000}, context.Background(),
+			object.StepLimitError, "execution budget exceeded: more than 1000 steps"},
+		{"var f = fn(x) { 1 + f(x) }; f(1)", object.Limits{}, context.Background(),
+			object.DepthLimitError, "maximum recursion depth exceeded: more than 10000 nested calls"},
+		{countdown + "f(10)", object.Limits{MaxDepth: 5}, context.Background(),
+			object.DepthLimitError, "maximum recursion depth exceeded: more than 5 nested calls"},
+		{"while (true) { }", object.Limits{}, canceled,
+			object.CanceledError, "execution canceled: context canceled"},
+		// tail calls run in a loop rather than nesting, so only steps and the
+		// context can stop endless tail recursion
+		{"var f = fn(x) { f(x) }; f(1)", object.Limits{MaxSteps: 1000}, context.Background(),
+			object.StepLimitError, "execution budget exceeded: more than 1000 steps"},
+		{"var f = fn(x) { f(x) }; f(1)", object.Limits{}, canceled,
+			object.CanceledError, "execution canceled: context canceled"},
+		// builtins are charged for the elements and the text they make
+		{"range(1000000)", object.Limits{MaxSteps: 1000}, context.Background(),
+			object.StepLimitError, "execution budget exceeded: more than 1000 steps"},
+		{"var a = range(600); concat(a, a)", object.Limits{MaxSteps: 1000}, context.Background(),
+			object.StepLimitError, "execution budget exceeded: more than 1000 steps"},
+		{`format("%999999d", 1)`, object.Limits{MaxSteps: 1000}, context.Background(),
+			object.StepLimitError, "execution budget exceeded: more than 1000 steps"},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnv()
+		env.SetLimits(tt.limits)
+
+		evaluated := EvalContext(tt.ctx, parser.New(lexer.New(tt.input)).ParseProgram(), env)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("object is not Error for %q. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Kind != tt.expected || errObj.Message != tt.message {
+			t.Errorf("wrong error for %q. want=%d %q, got=%d %q",
+				tt.input, tt.expected, tt.message, errObj.Kind, errObj.Message)
+		}
+	}
+
+	// the limits apply to each run, not to the environment as a whole
+	env := object.NewEnv()
+	env.SetLimits(object.Limits{MaxSteps: 100, MaxDepth: 5})
+	for i := 0; i < 3; i++ {
+		evaluated := EvalContext(context.Background(), parser.New(lexer.New(countdown+"f(4)")).ParseProgram(), env)
+		testIntegerObject(t, evaluated, 4)
+	}
+}
+
+func TestTailCalls(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected interface{}
 	}{
-		{`len("")`, 0},
-		{`len("four")`, 4},
-		{`len("hello world")`, 11},
-		{`len(1)`, "argument to `len` not supported, got INTEGER"},
-		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{"var loop = fn(n) { if (n == 0) { 0 } else { loop(n - 1) } }; loop(100000)", 0},
+		{"var sum = fn(n, acc) { if (n == 0) { return acc; } return sum(n - 1, acc + n); }; sum(100000, 0)", 5000050000},
+		{`var even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+var odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+even(100001)`, false},
+		{"var f = fn(n) { while (true) { if (n == 0) { return 7 } return f(n - 1) } }; f(50000)", 7},
+		{"var f = fn(n) { if (n > 0) { f(n - 1) } }; f(3)", nil},
+		{"var f = fn(xs) { len(xs) }; f([1, 2])", 2},
+		{"var f = fn(x) { x * 2 }; return f(21);", 42},
 	}
 
 	for _, tt := range tests {
@@ -287,20 +435,253 @@ func TestBuiltinFunctions(t *testing.T) {
 		switch expected := tt.expected.(type) {
 		case int:
 			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case nil:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestTailCallErrors(t *testing.T) {
+	// tail calls don't count against the call depth
+	env := object.NewEnv()
+	env.SetLimits(object.Limits{MaxDepth: 5})
+	evaluated := Eval(parser.New(lexer.New("var loop = fn(n) { if (n == 0) { 0 } else { loop(n - 1) } }; loop(1000)")).ParseProgram(), env)
+	testIntegerObject(t, evaluated, 0)
+
+	tests := []struct {
+		input     string
+		message   string
+		pos       string
+		stackSize int
+	}{
+		// the traceback keeps the most recent tail calls
+		{"var loop = fn(n) { if (n == 0) { 1 + true } else { loop(n - 1) } }; loop(1000)",
+			"type mismatch: INTEGER + BOOLEAN", "1:34", maxTailFrames + 1},
+		{"var f = fn(a) { a }; var g = fn() { f() }; g()",
+			"wrong number of arguments: want=1, got=0", "1:37", 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q: got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.message || errObj.Pos.String() != tt.pos {
+			t.Errorf("wrong error for %q. want=%s: %q, got=%s: %q",
+				tt.input, tt.pos, tt.message, errObj.Pos, errObj.Message)
+		}
+
+		if len(errObj.Stack) != tt.stackSize {
+			t.Errorf("wrong stack size for %q. want=%d, got=%d", tt.input, tt.stackSize, len(errObj.Stack))
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	for _, tt := range evaltest.Loops {
+		evaluated := testEval(tt.Input)
+
+		switch expected := tt.Expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case []int64:
+			array, ok := evaluated.(*object.Array)
+			if !ok {
+				t.Errorf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+
+			if len(array.Elements) != len(expected) {
+				t.Errorf("wrong num of elements. want=%d, got=%d", len(expected), len(array.Elements))
+				continue
+			}
+
+			for i, want := range expected {
+				testIntegerObject(t, array.Elements[i], want)
+			}
 		case string:
-			errObj, ok := evaluated.(*object.Error)
+			switch obj := evaluated.(type) {
+			case *object.String:
+				if obj.Value != expected {
+					t.Errorf("String has wrong value. got=%q, want=%q", obj.Value, expected)
+				}
+			case *object.Error:
+				if obj.Message != expected {
+					t.Errorf("wrong error message. expected=%q, got=%q", expected, obj.Message)
+				}
+			default:
+				t.Errorf("object is not String or Error. got=%T (%+v)", evaluated, evaluated)
+			}
+		}
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	for _, tt := range evaltest.Assignments {
+		evaluated := testEval(tt.Input)
+
+		switch expected := tt.Expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			switch obj := evaluated.(type) {
+			case *object.String:
+				if obj.Value != expected {
+					t.Errorf("String has wrong value. got=%q, want=%q", obj.Value, expected)
+				}
+			case *object.Error:
+				if obj.Message != expected {
+					t.Errorf("wrong error message. expected=%q, got=%q", expected, obj.Message)
+				}
+			default:
+				t.Errorf("object is not String or Error. got=%T (%+v)", evaluated, evaluated)
+			}
+		}
+	}
+}
+
+func TestArrayLiterals(t *testing.T) {
+	input := "[1, 2 * 2, 3 + 3]"
+	evaluated := testEval(input)
+
+	result, ok := evaluated.(*object.Array)
+	if !ok {
+		t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	if len(result.Elements) != 3 {
+		t.Fatalf("array has wrong num of elements. got=%d", len(result.Elements))
+	}
+
+	testIntegerObject(t, result.Elements[0], 1)
+	testIntegerObject(t, result.Elements[1], 4)
+	testIntegerObject(t, result.Elements[2], 6)
+
+	if result.Inspect() != "[1, 4, 6]" {
+		t.Errorf("array has wrong inspect output. got=%q", result.Inspect())
+	}
+}
+
+func TestArrayEquality(t *testing.T) {
+	for _, tt := range evaltest.ArrayEquality {
+		testBooleanObject(t, testEval(tt.Input), tt.Expected)
+	}
+}
+
+func TestIndexExpressions(t *testing.T) {
+	for _, tt := range evaltest.IndexExpressions {
+		evaluated := testEval(tt.Input)
+
+		switch expected := tt.Expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			s, ok := evaluated.(*object.String)
 			if !ok {
-				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
 				continue
 			}
 
-			if errObj.Message != expected {
-				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			if s.Value != expected {
+				t.Errorf("String has wrong value. got=%q, want=%q", s.Value, expected)
 			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestHashLiterals(t *testing.T) {
+	input := `var two = "two";
+	{
+		"one": 10 - 9,
+		two: 1 + 1,
+		"thr" + "ee": 6 / 2,
+		4: 4,
+		true: 5,
+		false: 6
+	}`
+
+	evaluated := testEval(input)
+	result, ok := evaluated.(*object.Hash)
+	if !ok {
+		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	expected := map[object.HashKey]int64{
+		(&object.String{Value: "one"}).HashKey():   1,
+		(&object.String{Value: "two"}).HashKey():   2,
+		(&object.String{Value: "three"}).HashKey(): 3,
+		(&object.Integer{Value: 4}).HashKey():      4,
+		TRUE.HashKey():                             5,
+		FALSE.HashKey():                            6,
+	}
+
+	if len(result.Pairs) != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	}
+
+	for expectedKey, expectedValue := range expected {
+		pair, ok := result.Pairs[expectedKey]
+		if !ok {
+			t.Errorf("no pair for given key in Pairs")
+		}
+
+		testIntegerObject(t, pair.Value, expectedValue)
+	}
+}
+
+func TestHashIndexExpressions(t *testing.T) {
+	for _, tt := range evaltest.HashIndexExpressions {
+		evaluated := testEval(tt.Input)
+		integer, ok := tt.Expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
 		}
 	}
 }
 
+func TestHashKeys(t *testing.T) {
+	hello1 := &object.String{Value: "Hello World"}
+	hello2 := &object.String{Value: "Hello World"}
+	diff := &object.String{Value: "My name is johnny"}
+
+	if hello1.HashKey() != hello2.HashKey() {
+		t.Errorf("strings with same content have different hash keys")
+	}
+
+	if hello1.HashKey() == diff.HashKey() {
+		t.Errorf("strings with different content have same hash keys")
+	}
+
+	one := &object.Integer{Value: 1}
+	if one.HashKey() == TRUE.HashKey() {
+		t.Errorf("objects of different types have same hash keys")
+	}
+}
+
+func TestHashInspect(t *testing.T) {
+	input := `{"b": 2, "a": [1, true], 3: "c"}`
+	evaluated := testEval(input)
+
+	expected := "{3: c, a: [1, true], b: 2}"
+	if evaluated.Inspect() != expected {
+		t.Errorf("wrong inspect output. want=%q, got=%q", expected, evaluated.Inspect())
+	}
+}
+
 func testEval(input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
@@ -325,6 +706,21 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	result, ok := obj.(*object.Boolean)
 	if !ok {
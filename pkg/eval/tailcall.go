@@ -0,0 +1,110 @@
+package eval
+
+import (
+	"monkey/pkg/ast"
+	"monkey/pkg/object"
+)
+
+// tailCallObj is the type of tail calls, which only the evaluator sees.
+const tailCallObj = "TAIL_CALL"
+
+// maxTailFrames is the number of tail calls, the most recent ones, that a
+// stack trace keeps track of.
+const maxTailFrames = 64
+
+// tailCall is what a call in tail position evaluates to: the call is left
+// for applyFunction to make once the calling function has returned. It never
+// escapes the evaluator.
+type tailCall struct {
+	node *ast.CallExpression
+	fn   object.Object
+	args []object.Object
+}
+
+func (tc *tailCall) Type() object.ObjectType {
+	return tailCallObj
+}
+
+func (tc *tailCall) Inspect() string {
+	return "tail call"
+}
+
+// evalTailBlock evaluates the body of a function like evalBlockStatement, its
+// last statement being in tail position.
+func evalTailBlock(block *ast.BlockStatement, env *object.Env) object.Object {
+	var result object.Object
+
+	for i, statement := range block.Statements {
+		if i == len(block.Statements)-1 {
+			if stmt, ok := statement.(*ast.ExpressionStatement); ok {
+				return evalTailExpression(stmt.Expression, env)
+			}
+		}
+
+		result = Eval(statement, env)
+
+		if result != nil {
+			switch result.Type() {
+			case object.RETURN_VAL_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+				return result
+			}
+		}
+	}
+
+	return result
+}
+
+// evalTailExpression evaluates an expression in tail position: a call is
+// returned as a *tailCall instead of being made, and so are the calls ending
+// the branches of an if expression.
+func evalTailExpression(node ast.Expression, env *object.Env) object.Object {
+	switch node := node.(type) {
+	case *ast.CallExpression:
+		function := Eval(node.Func, env)
+		if isError(function) {
+			return function
+		}
+
+		args := evalExpressions(node.Arguments, env)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0]
+		}
+
+		return &tailCall{node: node, fn: function, args: args}
+
+	case *ast.IfExpression:
+		condition := Eval(node.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+
+		if isTruthy(condition) {
+			return evalTailBlock(node.Consequence, env)
+		} else if node.Alternative != nil {
+			return evalTailBlock(node.Alternative, env)
+		}
+		return NULL
+
+	default:
+		return Eval(node, env)
+	}
+}
+
+// addTailFrames records the tail calls that led to err in its stack trace,
+// innermost first.
+func addTailFrames(err *object.Error, tailCalls []*tailCall) {
+	if len(tailCalls) == 0 {
+		return
+	}
+
+	if !err.Pos.IsValid() {
+		// the last call failed before its function ran, e.g. with the wrong
+		// number of arguments
+		err.Pos = tailCalls[len(tailCalls)-1].node.Pos()
+	}
+
+	for i := len(tailCalls) - 1; i >= 0; i-- {
+		tc := tailCalls[i]
+		err.Stack = append(err.Stack, stackFrame(tc.node, tc.fn, tc.args))
+	}
+}
@@ -0,0 +1,492 @@
+package format
+
+import (
+	"monkey/pkg/ast"
+	"monkey/pkg/lexer"
+	"monkey/pkg/parser"
+	"monkey/pkg/token"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Width is the line width the formatter tries to keep code within. Argument
+// lists, arrays and hashes that don't fit are broken into one element per
+// line.
+const Width = 80
+
+// tabWidth is the width of an indentation level when measuring lines.
+const tabWidth = 4
+
+// ParseError is returned by Source when the source cannot be parsed.
+type ParseError struct {
+	Diagnostics []parser.Diagnostic
+}
+
+func (e *ParseError) Error() string {
+	msgs := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		msgs[i] = d.String()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// Source formats the Monkey program in src and keeps its comments. The
+// filename is only used in the positions of parse errors. Formatting is
+// stable: formatting the result again doesn't change it.
+//
+// The output is indented with tabs, every statement is on its own line and
+// ends with a semicolon, except for loops, conditionals and the last statement
+// of a block. Parentheses are only kept where the precedence of the operators
+// requires them, and at most one blank line of the source is kept between
+// two statements.
+func Source(filename string, src []byte) ([]byte, error) {
+	p := parser.New(lexer.NewFile(filename, string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Diagnostics: p.Errors()}
+	}
+
+	pr := &printer{src: string(src)}
+	l := lexer.NewFileMode(filename, string(src), lexer.ScanComments)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		pr.tokens = append(pr.tokens, tok)
+		if tok.Type == token.COMMENT {
+			pr.comments = append(pr.comments, tok)
+		}
+	}
+
+	pr.statements(program.Statements, len(src), false)
+
+	return []byte(pr.out.String()), nil
+}
+
+// printer writes the formatted source of a program. A printer in oneLine mode
+// renders an expression without breaking lines where it can, which tells
+// whether the expression fits on the current line.
+type printer struct {
+	src      string
+	tokens   []token.Token // all the tokens of the source, comments included
+	comments []token.Token
+	next     int // index of the first comment not yet printed
+
+	out     strings.Builder
+	indent  int
+	col     int // width of the current line
+	oneLine bool
+}
+
+// write writes s, indenting it first when it starts a line.
+func (p *printer) write(s string) {
+	if s == "" {
+		return
+	}
+
+	if p.col == 0 {
+		p.out.WriteString(strings.Repeat("\t", p.indent))
+		p.col = p.indent * tabWidth
+	}
+
+	p.out.WriteString(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		p.col = utf8.RuneCountInString(s[i+1:])
+	} else {
+		p.col += utf8.RuneCountInString(s)
+	}
+}
+
+func (p *printer) newline() {
+	p.out.WriteByte('\n')
+	p.col = 0
+}
+
+// fits reports whether s can be written on the current line.
+func (p *printer) fits(s string) bool {
+	col := p.col
+	if col == 0 {
+		col = p.indent * tabWidth
+	}
+
+	return !strings.Contains(s, "\n") && col+utf8.RuneCountInString(s) <= Width
+}
+
+// statements prints a list of statements with the comments before end, the
+// offset of the closing brace of the enclosing block. The last statement of a
+// block doesn't need a semicolon.
+func (p *printer) statements(stmts []ast.Statement, end int, inBlock bool) {
+	first := true
+	for i, s := range stmts {
+		first = p.leadingComments(s.Pos().Offset, first)
+		if !first && p.blankLineBefore(s.Pos()) {
+			p.newline()
+		}
+		first = false
+
+		p.statement(s)
+
+		var next ast.Statement
+		if i+1 < len(stmts) {
+			next = stmts[i+1]
+		}
+		if p.needsSemicolon(s, next, inBlock) {
+			p.write(";")
+		}
+
+		boundary := end
+		if next != nil {
+			boundary = next.Pos().Offset
+		}
+		p.trailingComments(s.End().Line, boundary)
+		p.newline()
+	}
+
+	p.leadingComments(end, first)
+}
+
+// leadingComments prints the comments before offset on their own lines and
+// reports whether nothing has been printed in the block yet.
+func (p *printer) leadingComments(offset int, first bool) bool {
+	for p.next < len(p.comments) && p.comments[p.next].Pos.Offset < offset {
+		c := p.comments[p.next]
+		if !first && p.blankLineBefore(c.Pos) {
+			p.newline()
+		}
+		first = false
+
+		p.write(commentText(c))
+		p.newline()
+		p.next++
+	}
+
+	return first
+}
+
+// trailingComments prints the comments before offset that start on line, the
+// last line of the statement just printed, at the end of the current line.
+func (p *printer) trailingComments(line, offset int) {
+	for p.next < len(p.comments) {
+		c := p.comments[p.next]
+		if c.Pos.Offset >= offset || c.Pos.Line != line {
+			return
+		}
+
+		p.write(" " + commentText(c))
+		p.next++
+	}
+}
+
+func commentText(c token.Token) string {
+	return strings.TrimRight(c.Literal, " \t\r")
+}
+
+// blankLineBefore reports whether the source has an empty line between pos
+// and the token before it.
+func (p *printer) blankLineBefore(pos token.Position) bool {
+	i := sort.Search(len(p.tokens), func(i int) bool {
+		return p.tokens[i].Pos.Offset >= pos.Offset
+	})
+	if i == 0 {
+		return false
+	}
+
+	return pos.Line-p.tokens[i-1].End.Line > 1
+}
+
+// hasComments reports whether there are comments in the source between the
+// offsets start and end.
+func (p *printer) hasComments(start, end int) bool {
+	for _, c := range p.comments[p.next:] {
+		if c.Pos.Offset >= end {
+			break
+		}
+		if c.Pos.Offset >= start {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *printer) needsSemicolon(s, next ast.Statement, inBlock bool) bool {
+	switch s := s.(type) {
+	case *ast.WhileStatement, *ast.ForStatement, *ast.BlockStatement:
+		return false
+	case *ast.ExpressionStatement:
+		if _, ok := s.Expression.(*ast.IfExpression); ok {
+			// the next statement would otherwise continue the expression
+			return next != nil && strings.ContainsAny(p.render(next)[:1], "([-")
+		}
+	}
+
+	return next != nil || !inBlock
+}
+
+// render returns the statement s printed on a single line where possible.
+func (p *printer) render(s ast.Statement) string {
+	r := p.sub()
+	r.statement(s)
+	return r.out.String()
+}
+
+// sub returns a printer in oneLine mode that can render parts of the program
+// without printing the comments of p.
+func (p *printer) sub() *printer {
+	return &printer{src: p.src, tokens: p.tokens, comments: p.comments, next: p.next, oneLine: true}
+}
+
+func (p *printer) statement(s ast.Statement) {
+	switch s := s.(type) {
+	case *ast.VarStatement:
+		p.write("var " + s.Name.Value + " = ")
+		p.expr(s.Value)
+	case *ast.AssignStatement:
+		p.expr(s.Target)
+		p.write(" " + s.Operator + " ")
+		p.expr(s.Value)
+	case *ast.ReturnStatement:
+		p.write("return")
+		if s.ReturnValue != nil {
+			p.write(" ")
+			p.expr(s.ReturnValue)
+		}
+	case *ast.ExpressionStatement:
+		p.expr(s.Expression)
+	case *ast.WhileStatement:
+		p.write("while (")
+		p.expr(s.Condition)
+		p.write(") ")
+		p.block(s.Body)
+	case *ast.ForStatement:
+		p.write("for (" + s.Variable.Value + " in ")
+		p.expr(s.Iterable)
+		p.write(") ")
+		p.block(s.Body)
+	case *ast.BranchStatement:
+		p.write(s.Token.Literal)
+	case *ast.BlockStatement:
+		p.block(s)
+	}
+}
+
+// block prints b on a single line when it holds a single simple statement and
+// no comments, or with one statement per line otherwise.
+func (p *printer) block(b *ast.BlockStatement) {
+	if s, ok := p.inlineBlock(b); ok && (p.oneLine || p.fits(s)) {
+		p.write(s)
+		return
+	}
+
+	p.write("{")
+	p.newline()
+	p.indent++
+	p.statements(b.Statements, b.End().Offset-1, true)
+	p.indent--
+	p.write("}")
+}
+
+func (p *printer) inlineBlock(b *ast.BlockStatement) (string, bool) {
+	if p.hasComments(b.Pos().Offset, b.End().Offset) {
+		return "", false
+	}
+
+	switch len(b.Statements) {
+	case 0:
+		return "{}", true
+	case 1:
+		switch b.Statements[0].(type) {
+		case *ast.ExpressionStatement, *ast.ReturnStatement, *ast.AssignStatement, *ast.BranchStatement:
+		default:
+			return "", false
+		}
+
+		s := p.render(b.Statements[0])
+		return "{ " + s + " }", !strings.Contains(s, "\n")
+	default:
+		return "", false
+	}
+}
+
+// expr prints e on the current line if it fits and holds no comments,
+// breaking it otherwise.
+func (p *printer) expr(e ast.Expression) {
+	if !p.oneLine && !p.hasComments(e.Pos().Offset, e.End().Offset) {
+		r := p.sub()
+		r.expr(e)
+		if s := r.out.String(); p.fits(s) {
+			p.write(s)
+			return
+		}
+	}
+
+	switch e := e.(type) {
+	case *ast.Identifier:
+		p.write(e.Value)
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral:
+		// keep the spelling of the literal, like the escapes of strings
+		p.write(p.src[e.Pos().Offset:e.End().Offset])
+	case *ast.Boolean:
+		p.write(e.Token.Literal)
+	case *ast.PrefixExpression:
+		p.write(e.Operator)
+		// -(-1) must not read as --1
+		right, ok := e.Right.(*ast.PrefixExpression)
+		p.operand(e.Right, precedence(e.Right) < parser.PREFIX || ok && right.Operator == e.Operator)
+	case *ast.InfixExpression:
+		prec := parser.Precedence(e.Token.Type)
+		// operators are left-associative
+		p.operand(e.Left, precedence(e.Left) < prec)
+		p.write(" " + e.Operator + " ")
+		p.operand(e.Right, precedence(e.Right) <= prec)
+	case *ast.CallExpression:
+		p.operand(e.Func, precedence(e.Func) < parser.CALL)
+		p.list("(", ")", e.Token.Pos.Offset, e.End().Offset-1, expressionItems(e.Arguments))
+	case *ast.IndexExpression:
+		p.operand(e.Left, precedence(e.Left) < parser.CALL)
+		p.write("[")
+		p.expr(e.Index)
+		p.write("]")
+	case *ast.IfExpression:
+		p.write("if (")
+		p.expr(e.Condition)
+		p.write(") ")
+		p.block(e.Consequence)
+		if e.Alternative != nil {
+			p.write(" else ")
+			p.block(e.Alternative)
+		}
+	case *ast.FunctionLiteral:
+		params := make([]string, len(e.Parameters))
+		for i, param := range e.Parameters {
+			params[i] = param.Value
+		}
+		p.write("fn(" + strings.Join(params, ", ") + ") ")
+		p.block(e.Body)
+	case *ast.ArrayListeral:
+		p.list("[", "]", e.Pos().Offset, e.End().Offset-1, expressionItems(e.Elements))
+	case *ast.HashLiteral:
+		items := make([]item, len(e.Pairs))
+		for i, pair := range e.Pairs {
+			pair := pair
+			items[i] = item{pair.Key.Pos(), pair.Value.End(), func(p *printer) {
+				p.expr(pair.Key)
+				p.write(": ")
+				p.expr(pair.Value)
+			}}
+		}
+		p.list("{", "}", e.Pos().Offset, e.End().Offset-1, items)
+	}
+}
+
+func (p *printer) operand(e ast.Expression, parens bool) {
+	if !parens {
+		p.expr(e)
+		return
+	}
+
+	p.write("(")
+	p.expr(e)
+	p.write(")")
+}
+
+// precedence returns how tightly e binds as the operand of an operator.
+func precedence(e ast.Expression) int {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		return parser.Precedence(e.Token.Type)
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.CallExpression:
+		return parser.CALL
+	default:
+		return parser.INDEX
+	}
+}
+
+// item is an element of a list, spanning start to end in the source.
+type item struct {
+	start, end token.Position
+	print      func(*printer)
+}
+
+func expressionItems(exprs []ast.Expression) []item {
+	items := make([]item, len(exprs))
+	for i, e := range exprs {
+		e := e
+		items[i] = item{e.Pos(), e.End(), func(p *printer) { p.expr(e) }}
+	}
+
+	return items
+}
+
+// list prints the items between open and close, found at the offsets start and
+// end of the source, separated by commas. When they don't fit on the current
+// line, a last item spanning several lines, like a function literal, is kept
+// on the line of the others if they fit. Otherwise, or when there are comments
+// between the items, every item goes on its own line along with its comments.
+func (p *printer) list(open, close string, start, end int, items []item) {
+	commented := false
+	for i, offset := 0, start; i <= len(items) && !commented; i++ {
+		next := end
+		if i < len(items) {
+			next = items[i].start.Offset
+		}
+		commented = p.hasComments(offset, next)
+		if i < len(items) {
+			offset = items[i].end.Offset
+		}
+	}
+
+	if p.oneLine || len(items) == 0 && !commented {
+		p.write(open)
+		for i, item := range items {
+			if i > 0 {
+				p.write(", ")
+			}
+			item.print(p)
+		}
+		p.write(close)
+		return
+	}
+
+	last := len(items) - 1
+	if !commented && last >= 0 {
+		rendered := make([]string, len(items))
+		for i, item := range items {
+			r := p.sub()
+			item.print(r)
+			rendered[i] = r.out.String()
+		}
+
+		if head := open + strings.Join(rendered[:last], ", "); strings.Contains(rendered[last], "\n") {
+			if last > 0 {
+				head += ", "
+			}
+			if p.fits(head) && !strings.Contains(head, "\n") {
+				p.write(head)
+				items[last].print(p)
+				p.write(close)
+				return
+			}
+		}
+	}
+
+	p.write(open)
+	p.newline()
+	p.indent++
+	first := true
+	for i, item := range items {
+		first = p.leadingComments(item.start.Offset, first)
+		item.print(p)
+		boundary := end
+		if i < last {
+			p.write(",")
+			boundary = items[i+1].start.Offset
+		}
+		p.trailingComments(item.end.Line, boundary)
+		p.newline()
+	}
+	p.leadingComments(end, first)
+	p.indent--
+	p.write(close)
+}
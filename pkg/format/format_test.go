@@ -0,0 +1,123 @@
+package format
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"var x=1", "var x = 1;\n"},
+		{"x+=1;y", "x += 1;\ny;\n"},
+		{"var s = \"a\\tb\"; 1.50", "var s = \"a\\tb\";\n1.50;\n"},
+		// parentheses
+		{"(1 + 2) * 3", "(1 + 2) * 3;\n"},
+		{"1 + (2 * 3)", "1 + 2 * 3;\n"},
+		{"(1 - 2) - 3", "1 - 2 - 3;\n"},
+		{"1 - (2 - 3)", "1 - (2 - 3);\n"},
+		{"((a))", "a;\n"},
+		{"-(a + b)", "-(a + b);\n"},
+		{"-(a[0])", "-a[0];\n"},
+		{"(-a)[0]", "(-a)[0];\n"},
+		{"(f(x))[0]", "f(x)[0];\n"},
+		{"(fn(x) { x })(1)", "fn(x) { x }(1);\n"},
+		{"!(a && b) || (c || d)", "!(a && b) || (c || d);\n"},
+		{"(a || b) && c", "(a || b) && c;\n"},
+		{"(a < b) == (c > d)", "a < b == c > d;\n"},
+		{"-(-1)", "-(-1);\n"},
+		{"!(!a)", "!(!a);\n"},
+		{"-(!a)", "-!a;\n"},
+		// blocks
+		{"fn(){}", "fn() {};\n"},
+		{"if(x){1}else{2}", "if (x) { 1 } else { 2 }\n"},
+		{"if (x) { var y = 1; y }", "if (x) {\n\tvar y = 1;\n\ty\n}\n"},
+		{"while (i < 3) { i += 1 }", "while (i < 3) { i += 1 }\n"},
+		{"for (x in xs) { puts(x); puts(x) }", "for (x in xs) {\n\tputs(x);\n\tputs(x)\n}\n"},
+		{"while (true) { break }", "while (true) { break }\n"},
+		{"var f = fn(x) { fn(y) { x + y } }", "var f = fn(x) { fn(y) { x + y } };\n"},
+		// an if statement followed by a statement that would continue it
+		{"if (x) { 1 }; -1", "if (x) { 1 };\n-1;\n"},
+		{"if (x) { 1 }; [1]", "if (x) { 1 };\n[1];\n"},
+		{"if (x) { 1 }; y", "if (x) { 1 }\ny;\n"},
+		// blank lines
+		{"a\n\n\n\nb\nc", "a;\n\nb;\nc;\n"},
+		{"\n\nfn() {\n\n  a\n\n  b\n\n}", "fn() {\n\ta;\n\n\tb\n};\n"},
+		// comments
+		{"// a\nx // b\n/* c */ y", "// a\nx; // b\n/* c */\ny;\n"},
+		{"fn() {\n  // only a comment\n}", "fn() {\n\t// only a comment\n};\n"},
+		{"fn() { x // done\n}", "fn() {\n\tx // done\n};\n"},
+		{"x\n\n// end   ", "x;\n\n// end\n"},
+		{"map(xs, fn(x) {\n  // double\n  x * 2\n})", "map(xs, fn(x) {\n\t// double\n\tx * 2\n});\n"},
+		{"var xs = [\n  1, // one\n  // two\n  2\n]; y", "var xs = [\n\t1, // one\n\t// two\n\t2\n];\ny;\n"},
+		{"f(a, /* b */ b, {\n  \"c\": 1 // c\n})", "f(\n\ta, /* b */\n\tb,\n\t{\n\t\t\"c\": 1 // c\n\t}\n);\n"},
+		{"f(\n  // nothing\n)", "f(\n\t// nothing\n);\n"},
+		// wrapping
+		{
+			`var long = someFunction("a long string argument", "another long string argument", 12345)`,
+			"var long = someFunction(\n\t\"a long string argument\",\n\t\"another long string argument\",\n\t12345\n);\n",
+		},
+		{
+			`var h = {"one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7}`,
+			"var h = {\n\t\"one\": 1,\n\t\"two\": 2,\n\t\"three\": 3,\n\t\"four\": 4,\n\t\"five\": 5,\n\t\"six\": 6,\n\t\"seven\": 7\n};\n",
+		},
+		{
+			"puts(map([1, 2, 3], fn(x) { var y = x * 2; y + 1 }))",
+			"puts(map([1, 2, 3], fn(x) {\n\tvar y = x * 2;\n\ty + 1\n}));\n",
+		},
+	}
+
+	for _, tt := range tests {
+		out, err := Source("test.mk", []byte(tt.input))
+		if err != nil {
+			t.Fatalf("Source(%q) returned error: %s", tt.input, err)
+		}
+
+		if string(out) != tt.expected {
+			t.Errorf("Source(%q) wrong.\nwant=%q\ngot= %q", tt.input, tt.expected, out)
+		}
+
+		again, err := Source("test.mk", out)
+		if err != nil {
+			t.Fatalf("Source(%q) returned error: %s", out, err)
+		}
+
+		if string(again) != string(out) {
+			t.Errorf("formatting %q is not stable.\nfirst= %q\nsecond=%q", tt.input, out, again)
+		}
+	}
+}
+
+func TestSourceKeepsLinesWithinWidth(t *testing.T) {
+	input := `var table = [[1, 2, 3, 4, 5, 6, 7, 8, 9, 10], [11, 12, 13, 14, 15, 16, 17, 18, 19, 20], [21, 22, 23]];
+var f = fn(a, b) { if (a > b) { return combine(first(a), rest(b), "some text that is long enough") } else { b } };`
+
+	out, err := Source("test.mk", []byte(input))
+	if err != nil {
+		t.Fatalf("Source returned error: %s", err)
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		width := len(strings.ReplaceAll(line, "\t", "    "))
+		if width > Width {
+			t.Errorf("line wider than %d columns: %q\n%s", Width, line, out)
+		}
+	}
+}
+
+func TestSourceParseError(t *testing.T) {
+	_, err := Source("bad.mk", []byte("var = 1"))
+
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected a *ParseError, got %T (%v)", err, err)
+	}
+
+	expected := "bad.mk:1:5: error: expected next token to be IDENT, got== instead"
+	if err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%q", expected, err.Error())
+	}
+}
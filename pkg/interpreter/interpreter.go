@@ -0,0 +1,140 @@
+package interpreter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"monkey/pkg/engine"
+	"monkey/pkg/lexer"
+	"monkey/pkg/object"
+	"monkey/pkg/parser"
+	"strings"
+)
+
+// Config customizes an Interpreter.
+type Config struct {
+	// Engine executes the programs, the tree-walking evaluator is used when
+	// nil.
+	Engine engine.Engine
+	// Stdout receives the output of builtins like puts, os.Stdout when nil.
+	Stdout io.Writer
+	// Stderr receives the diagnostics of builtins, os.Stderr when nil.
+	Stderr io.Writer
+	// Limits bound the resources of every run and call, so that untrusted
+	// scripts can't hang or crash the host.
+	Limits object.Limits
+}
+
+// Interpreter runs Monkey programs on behalf of a Go host. Bindings made by a
+// program or by the host persist between runs, so a host can define values
+// and builtins, run a script and then call the functions it defined.
+type Interpreter struct {
+	engine engine.Engine
+}
+
+// New returns an interpreter with the given configuration.
+func New(cfg Config) *Interpreter {
+	e := cfg.Engine
+	if e == nil {
+		e = engine.NewEvaluator()
+	}
+
+	if cfg.Stdout != nil {
+		e.SetStdout(cfg.Stdout)
+	}
+	if cfg.Stderr != nil {
+		e.SetStderr(cfg.Stderr)
+	}
+	e.SetLimits(cfg.Limits)
+
+	return &Interpreter{engine: e}
+}
+
+// ParseError is returned by Run when the source cannot be parsed.
+type ParseError struct {
+	Diagnostics []parser.Diagnostic
+}
+
+func (e *ParseError) Error() string {
+	msgs := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		msgs[i] = d.String()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// RuntimeError is returned when a program or a call fails while running. The
+// Kind of Err tells whether it exceeded its limits.
+type RuntimeError struct {
+	Err *object.Error
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Err.Pos.IsValid() {
+		return e.Err.Pos.String() + ": " + e.Err.Message
+	}
+
+	return e.Err.Message
+}
+
+// Run parses and executes src and returns the value of its last statement,
+// which is nil when that statement produces no value.
+func (i *Interpreter) Run(src string) (object.Object, error) {
+	return i.RunContext(context.Background(), src)
+}
+
+// RunContext is like Run but stops the program once ctx is done.
+func (i *Interpreter) RunContext(ctx context.Context, src string) (object.Object, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Diagnostics: p.Errors()}
+	}
+
+	return result(i.engine.RunContext(ctx, program))
+}
+
+// Call calls the function or builtin bound to name in the global scope.
+func (i *Interpreter) Call(name string, args ...object.Object) (object.Object, error) {
+	return i.CallContext(context.Background(), name, args...)
+}
+
+// CallContext is like Call but stops the call once ctx is done.
+func (i *Interpreter) CallContext(ctx context.Context, name string, args ...object.Object) (object.Object, error) {
+	fn, ok := i.engine.Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("identifier not found: %s", name)
+	}
+
+	if fn.Type() != object.FUNC_OBJ && fn.Type() != object.BUILTIN_OBJ {
+		return nil, fmt.Errorf("not a function: %s is %s", name, fn.Type())
+	}
+
+	return result(i.engine.Call(ctx, fn, args))
+}
+
+// Define binds name to value in the global scope.
+func (i *Interpreter) Define(name string, value object.Object) {
+	i.engine.Define(name, value)
+}
+
+// Lookup returns the value bound to name in the global scope.
+func (i *Interpreter) Lookup(name string) (object.Object, bool) {
+	return i.engine.Lookup(name)
+}
+
+// RegisterBuiltin makes fn callable as name by the programs this interpreter
+// runs. The builtin is only visible to this interpreter and takes precedence
+// over a standard builtin with the same name.
+func (i *Interpreter) RegisterBuiltin(name string, fn object.BuiltInFunc) {
+	i.engine.Define(name, &object.Builtin{Fn: fn})
+}
+
+func result(obj object.Object) (object.Object, error) {
+	if errObj, ok := obj.(*object.Error); ok {
+		return nil, &RuntimeError{Err: errObj}
+	}
+
+	return obj, nil
+}
@@ -0,0 +1,221 @@
+package interpreter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"monkey/pkg/engine"
+	"monkey/pkg/object"
+	"testing"
+	"time"
+)
+
+func newInterpreters(t *testing.T, stdout, stderr *bytes.Buffer) map[string]*Interpreter {
+	t.Helper()
+
+	interpreters := map[string]*Interpreter{}
+	for _, name := range []string{engine.Eval, engine.VM} {
+		e, err := engine.New(name)
+		if err != nil {
+			t.Fatalf("engine.New(%q) returned error: %s", name, err)
+		}
+
+		interpreters[name] = New(Config{Engine: e, Stdout: stdout, Stderr: stderr})
+	}
+
+	return interpreters
+}
+
+func TestRun(t *testing.T) {
+	for name, interp := range newInterpreters(t, nil, nil) {
+		result, err := interp.Run("var double = fn(x) { x * 2 }; double(21)")
+		if err != nil {
+			t.Fatalf("%s: Run returned error: %s", name, err)
+		}
+
+		if result.Inspect() != "42" {
+			t.Errorf("%s: wrong result. got=%s", name, result.Inspect())
+		}
+
+		// bindings persist between runs
+		result, err = interp.Run("double(2)")
+		if err != nil || result.Inspect() != "4" {
+			t.Errorf("%s: wrong result of second run. got=%v, %v", name, result, err)
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	for name, interp := range newInterpreters(t, nil, nil) {
+		_, err := interp.Run("var = 1")
+		if _, ok := err.(*ParseError); !ok {
+			t.Errorf("%s: expected *ParseError, got=%T (%v)", name, err, err)
+		}
+
+		_, err = interp.Run("1 + true")
+		runtimeErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("%s: expected *RuntimeError, got=%T (%v)", name, err, err)
+		}
+
+		if runtimeErr.Err.Message != "type mismatch: INTEGER + BOOLEAN" {
+			t.Errorf("%s: wrong error message. got=%q", name, runtimeErr.Err.Message)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	for name, interp := range newInterpreters(t, nil, nil) {
+		if _, err := interp.Run("var base = 10; var add = fn(a, b) { a + b + base };"); err != nil {
+			t.Fatalf("%s: Run returned error: %s", name, err)
+		}
+
+		result, err := interp.Call("add", &object.Integer{Value: 1}, &object.Integer{Value: 2})
+		if err != nil || result.Inspect() != "13" {
+			t.Errorf("%s: wrong result of add. got=%v, %v", name, result, err)
+		}
+
+		result, err = interp.Call("len", &object.String{Value: "abc"})
+		if err != nil || result.Inspect() != "3" {
+			t.Errorf("%s: wrong result of len. got=%v, %v", name, result, err)
+		}
+
+		tests := []struct {
+			name     string
+			args     []object.Object
+			expected string
+		}{
+			{"missing", nil, "identifier not found: missing"},
+			{"base", nil, "not a function: base is INTEGER"},
+			{"add", nil, "wrong number of arguments: want=2, got=0"},
+			{"add", []object.Object{&object.Integer{Value: 1}, &object.Boolean{Value: true}}, "type mismatch: INTEGER + BOOLEAN"},
+		}
+
+		for _, tt := range tests {
+			_, err := interp.Call(tt.name, tt.args...)
+			if err == nil {
+				t.Errorf("%s: expected an error calling %s", name, tt.name)
+				continue
+			}
+
+			if runtimeErr, ok := err.(*RuntimeError); ok {
+				err = fmt.Errorf("%s", runtimeErr.Err.Message)
+			}
+
+			if err.Error() != tt.expected {
+				t.Errorf("%s: wrong error calling %s. want=%q, got=%q", name, tt.name, tt.expected, err)
+			}
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	for _, name := range []string{engine.Eval, engine.VM} {
+		e, err := engine.New(name)
+		if err != nil {
+			t.Fatalf("engine.New(%q) returned error: %s", name, err)
+		}
+
+		interp := New(Config{Engine: e, Limits: object.Limits{MaxSteps: 100000}})
+
+		_, err = interp.Run("while (true) { }")
+		if runtimeErr, ok := err.(*RuntimeError); !ok || runtimeErr.Err.Kind != object.StepLimitError {
+			t.Errorf("%s: expected a step limit error, got=%v", name, err)
+		}
+
+		// without a step limit only the context stops the call
+		interp.engine.SetLimits(object.Limits{})
+		if _, err := interp.Run("var spin = fn() { while (true) { } };"); err != nil {
+			t.Fatalf("%s: Run returned error: %s", name, err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		_, err = interp.CallContext(ctx, "spin")
+		cancel()
+
+		if runtimeErr, ok := err.(*RuntimeError); !ok || runtimeErr.Err.Kind != object.CanceledError {
+			t.Errorf("%s: expected a canceled error, got=%v", name, err)
+		}
+	}
+}
+
+func TestDefineAndRegisterBuiltin(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	interpreters := newInterpreters(t, &stdout, &stderr)
+
+	for name, interp := range interpreters {
+		stdout.Reset()
+		stderr.Reset()
+
+		interp.Define("greeting", &object.String{Value: "hello"})
+		interp.RegisterBuiltin("twice", func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return &object.Error{Message: "twice takes a function and a value"}
+			}
+
+			fmt.Fprintln(rt.Stderr(), "twice called")
+			return rt.Call(args[0], []object.Object{rt.Call(args[0], args[1:])})
+		})
+
+		result, err := interp.Run(`puts(greeting); twice(fn(x) { x + "!" }, greeting)`)
+		if err != nil {
+			t.Fatalf("%s: Run returned error: %s", name, err)
+		}
+
+		if result.Inspect() != "hello!!" {
+			t.Errorf("%s: wrong result. got=%s", name, result.Inspect())
+		}
+
+		if stdout.String() != "hello\n" {
+			t.Errorf("%s: wrong stdout. got=%q", name, stdout.String())
+		}
+
+		if stderr.String() != "twice called\n" {
+			t.Errorf("%s: wrong stderr. got=%q", name, stderr.String())
+		}
+
+		_, err = interp.Run("twice(1)")
+		if runtimeErr, ok := err.(*RuntimeError); !ok || runtimeErr.Err.Message != "twice takes a function and a value" {
+			t.Errorf("%s: wrong error from builtin. got=%v", name, err)
+		}
+	}
+
+	// builtins are registered per interpreter
+	other := New(Config{})
+	if _, err := other.Run("twice"); err == nil {
+		t.Errorf("builtin leaked into another interpreter")
+	}
+}
+
+func TestHostBooleans(t *testing.T) {
+	for name, interp := range newInterpreters(t, nil, nil) {
+		interp.Define("flag", &object.Boolean{Value: false})
+		interp.Define("nothing", &object.Null{})
+		interp.RegisterBuiltin("yes", func(rt object.Runtime, args ...object.Object) object.Object {
+			return &object.Boolean{Value: true}
+		})
+
+		tests := []struct {
+			input    string
+			expected string
+		}{
+			{"if (flag) { 1 } else { 2 }", "2"},
+			{"[flag == false, flag != false, !flag]", "[true, false, true]"},
+			{"if (nothing) { 1 } else { 2 }", "2"},
+			{"[nothing == first([]), !nothing]", "[true, true]"},
+			{"[yes() == true, !yes(), yes() && flag]", "[true, false, false]"},
+			{"if (yes()) { 1 } else { 2 }", "1"},
+		}
+
+		for _, tt := range tests {
+			result, err := interp.Run(tt.input)
+			if err != nil {
+				t.Fatalf("%s: Run(%q) returned error: %s", name, tt.input, err)
+			}
+
+			if result.Inspect() != tt.expected {
+				t.Errorf("%s: wrong result for %q. want=%s, got=%s", name, tt.input, tt.expected, result.Inspect())
+			}
+		}
+	}
+}
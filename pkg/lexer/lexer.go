@@ -1,71 +1,283 @@
 package lexer
 
-import "monkey/pkg/token"
+import (
+	"monkey/pkg/token"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Mode controls optional lexer behaviour.
+type Mode uint
+
+const (
+	// ScanComments makes the lexer return comments as COMMENT tokens instead
+	// of skipping them, which tools like a formatter need.
+	ScanComments Mode = 1 << iota
+)
 
 type Lexer struct {
-	input   string
-	pos     int  // current position in the input (points to current char)
-	readPos int  // current reading position in input (after current char)
-	ch      byte // current char under examination
+	input    string
+	filename string // name reported in token positions, may be empty
+	mode     Mode
+	pos      int  // current position in the input (points to current char)
+	readPos  int  // current reading position in input (after current char)
+	ch       rune // current char under examination
+	line     int  // line of the current char
+	col      int  // column of the current char, counted in runes
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewFile("", input)
+}
+
+// NewFile returns a lexer whose token positions refer to the given file name.
+func NewFile(filename, input string) *Lexer {
+	return NewFileMode(filename, input, 0)
+}
+
+// NewFileMode is like NewFile but with the given mode.
+func NewFileMode(filename, input string, mode Mode) *Lexer {
+	l := &Lexer{input: input, filename: filename, mode: mode, line: 1}
 	l.readChar()
 	return l
 }
 
 // readChar points to the next character and advances the read and current positions
-// in the input string.
+// in the input string. The input is decoded as UTF-8, an invalid byte is read as
+// utf8.RuneError. It also keeps track of the line and column of the current
+// character.
 func (l *Lexer) readChar() {
+	if l.readPos > len(l.input) {
+		// already at the end of the input
+		return
+	}
+
+	if l.ch == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+
+	width := 1
 	if l.readPos >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPos]
+		l.ch, width = utf8.DecodeRuneInString(l.input[l.readPos:])
 	}
 
 	l.pos = l.readPos
-	l.readPos += 1
+	l.readPos += width
 }
 
-// readInteger points to the next character and advances the read and current positions
-// in the input string until it reads the entire number character by character.
-func (l *Lexer) readInteger() string {
+// position returns the source position of the current character.
+func (l *Lexer) position() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.pos,
+		Line:     l.line,
+		Column:   l.col,
+	}
+}
+
+// readNumber points to the next character and advances the read and current positions
+// in the input string until it reads the entire number character by character. A
+// fractional part or an exponent turn the number into a float. An exponent
+// without digits, as in "1.5e", yields an ILLEGAL token.
+func (l *Lexer) readNumber() (token.TokenType, string) {
 	pos := l.pos
-	for isDigit(l.ch) {
+	tokenType := token.TokenType(token.INT)
+
+	l.readDigits()
+
+	if l.ch == '.' && isDigit(l.peek()) {
+		tokenType = token.FLOAT
 		l.readChar()
+		l.readDigits()
 	}
 
-	return l.input[pos:l.pos]
+	if l.ch == 'e' || l.ch == 'E' {
+		tokenType = token.FLOAT
+		l.readChar()
+		if l.ch == '+' || l.ch == '-' {
+			l.readChar()
+		}
+		if !isDigit(l.ch) {
+			tokenType = token.ILLEGAL
+		}
+		l.readDigits()
+	}
+
+	return tokenType, l.input[pos:l.pos]
+}
+
+func (l *Lexer) readDigits() {
+	for isDigit(l.ch) {
+		l.readChar()
+	}
 }
 
 // readIdent reads in an identifier and advances the lexer position until it
-// encounters a non-letter character.
+// encounters a character that can't be part of it. An identifier starts with a
+// letter and goes on with letters and digits, where letters are the Unicode
+// letters and '_' and digits are the Unicode decimal digits, so "ñame",
+// "π" and "x2" are all identifiers.
 func (l *Lexer) readIdent() string {
 	pos := l.pos
 
-	for isLetter(l.ch) {
+	for isLetter(l.ch) || unicode.IsDigit(l.ch) {
 		l.readChar()
 	}
 
 	return l.input[pos:l.pos]
 }
 
-// readString points to the next character and advances the read and current positions
-// until it encounters a closing '"" or EOF
-func (l *Lexer) readString() string {
-	// TODO: add support for character scaping
+// readString reads a double quoted string and decodes its escape sequences.
+// The returned token is an ILLEGAL one either for an unterminated string, with
+// the raw text up to the end of the input as its literal, or for the first
+// invalid escape sequence, positioned on that sequence. The lexer is left on
+// the closing quote.
+func (l *Lexer) readString() token.Token {
+	pos := l.pos
+	var out strings.Builder
+	var invalid token.Token
+
+	for {
+		l.readChar()
+
+		switch l.ch {
+		case '"':
+			if invalid.Type != "" {
+				return invalid
+			}
+			return token.Token{Type: token.STRING, Literal: out.String()}
+		case 0:
+			return token.Token{Type: token.ILLEGAL, Literal: l.input[pos:l.pos]}
+		case '\\':
+			start := l.position()
+			r, ok := l.readEscape()
+			if !ok && invalid.Type == "" {
+				invalid = token.Token{
+					Type:    token.ILLEGAL,
+					Literal: l.input[start.Offset : l.pos+1],
+					Pos:     start,
+					End:     l.peekPosition(),
+				}
+			}
+			out.WriteRune(r)
+		default:
+			// copy the source bytes rather than l.ch so invalid UTF-8 is kept
+			out.WriteString(l.input[l.pos:l.readPos])
+		}
+	}
+}
+
+// readEscape decodes the escape sequence starting at the current backslash and
+// leaves the lexer on its last character. It reports whether the sequence is
+// valid.
+func (l *Lexer) readEscape() (rune, bool) {
+	if l.peek() == 0 {
+		// let the caller report the unterminated string
+		return 0, false
+	}
+
+	l.readChar()
+	switch l.ch {
+	case 'n':
+		return '\n', true
+	case 't':
+		return '\t', true
+	case 'r':
+		return '\r', true
+	case '\\':
+		return '\\', true
+	case '"':
+		return '"', true
+	case 'u':
+		return l.readUnicodeEscape()
+	}
+
+	return utf8.RuneError, false
+}
+
+// readUnicodeEscape reads the "{XXXX}" part of a "\u{XXXX}" escape, made of one
+// to six hex digits naming a valid code point.
+func (l *Lexer) readUnicodeEscape() (rune, bool) {
+	if l.peek() != '{' {
+		return utf8.RuneError, false
+	}
+	l.readChar()
+
+	var r rune
+	digits := 0
+	for isHexDigit(l.peek()) {
+		l.readChar()
+		r = r*16 + hexValue(l.ch)
+		digits++
+	}
 
-	pos := l.pos + 1
+	if l.peek() != '}' {
+		return utf8.RuneError, false
+	}
+	l.readChar()
+
+	if digits == 0 || digits > 6 || !utf8.ValidRune(r) {
+		return utf8.RuneError, false
+	}
+
+	return r, true
+}
+
+// readRawString reads a backquoted string, which has no escape sequences and
+// may span several lines. An unterminated one yields an ILLEGAL token like in
+// readString.
+func (l *Lexer) readRawString() token.Token {
+	pos := l.pos
 
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+
+		switch l.ch {
+		case '`':
+			return token.Token{Type: token.STRING, Literal: l.input[pos+1 : l.pos]}
+		case 0:
+			return token.Token{Type: token.ILLEGAL, Literal: l.input[pos:l.pos]}
 		}
 	}
+}
 
-	return l.input[pos:l.pos]
+// readComment reads a "//" comment up to the end of the line or a "/* */"
+// comment up to its matching "*/". Block comments nest, so commenting out code
+// that already contains one works. It reports whether the comment was
+// terminated, which line comments always are.
+func (l *Lexer) readComment() (string, bool) {
+	pos := l.pos
+
+	l.readChar()
+	if l.ch == '/' {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+
+		return l.input[pos:l.pos], true
+	}
+
+	l.readChar()
+	depth := 1
+	for depth > 0 && l.ch != 0 {
+		switch {
+		case l.ch == '/' && l.peek() == '*':
+			depth++
+			l.readChar()
+		case l.ch == '*' && l.peek() == '/':
+			depth--
+			l.readChar()
+		}
+		l.readChar()
+	}
+
+	return l.input[pos:l.pos], depth == 0
 }
 
 // eatWhitespace is a helper function that advances the lexer position when it
@@ -77,42 +289,94 @@ func (l *Lexer) eatWhitespace() {
 }
 
 // isLetter is a helper function that just checks whether the given argument is a
-// letter.
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// Unicode letter or an underscore.
+func isLetter(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
-func newToken(tokenType token.TokenType, char byte) token.Token {
+func newToken(tokenType token.TokenType, char rune) token.Token {
 	return token.Token{Type: tokenType, Literal: string(char)}
 }
 
-// isDigit is a helper function that just checks whether the given argument is a
-// digit.
-func isDigit(ch byte) bool {
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+func hexValue(ch rune) rune {
+	switch {
+	case isDigit(ch):
+		return ch - '0'
+	case 'a' <= ch && ch <= 'f':
+		return ch - 'a' + 10
+	default:
+		return ch - 'A' + 10
+	}
+}
+
+// isDigit is a helper function that just checks whether the given argument is an
+// ASCII digit, number literals don't accept other digits.
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
 // peek doesn't increment the position or the read position, instead it "peeks"
 // ahead in the input and returns the next character.
-func (l *Lexer) peek() byte {
+func (l *Lexer) peek() rune {
 	if l.readPos >= len(l.input) {
 		return 0
 	} else {
-		return l.input[l.readPos]
+		ch, _ := utf8.DecodeRuneInString(l.input[l.readPos:])
+		return ch
 	}
 }
 
+// readTwoCharToken returns a token of type two when the current character is
+// followed by next, consuming both, or a token of type one for the current
+// character alone.
+func (l *Lexer) readTwoCharToken(next rune, two, one token.TokenType) token.Token {
+	if l.peek() != next {
+		return newToken(one, l.ch)
+	}
+
+	ch := l.ch
+	l.readChar()
+	return token.Token{Type: two, Literal: string(ch) + string(l.ch)}
+}
+
+// peekPosition returns the source position of the next character.
+func (l *Lexer) peekPosition() token.Position {
+	pos := l.position()
+	pos.Offset = l.readPos
+	pos.Column++
+	if l.ch == '\n' {
+		pos.Line++
+		pos.Column = 1
+	}
+
+	return pos
+}
+
 // NextToken looks at the current character under examination and returns a token
 // depending on which character it is. Before returning the token it also
 // advances the position into the input.
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 	l.eatWhitespace()
+	start := l.position()
 
 	switch l.ch {
-	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+	case '"', '`':
+		if l.ch == '"' {
+			tok = l.readString()
+		} else {
+			tok = l.readRawString()
+		}
+		l.readChar()
+		if !tok.Pos.IsValid() {
+			tok.Pos, tok.End = start, l.position()
+		}
+		return tok
 	case '=':
 		if l.peek() == '=' {
 			ch := l.ch
@@ -123,9 +387,9 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok = l.readTwoCharToken('=', token.PLUS_ASSIGN, token.PLUS)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		tok = l.readTwoCharToken('=', token.MINUS_ASSIGN, token.MINUS)
 	case '!':
 		if l.peek() == '=' {
 			ch := l.ch
@@ -137,15 +401,34 @@ func (l *Lexer) NextToken() token.Token {
 		}
 
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		if l.peek() == '/' || l.peek() == '*' {
+			comment, terminated := l.readComment()
+			switch {
+			case !terminated:
+				tok = token.Token{Type: token.ILLEGAL, Literal: comment}
+			case l.mode&ScanComments == 0:
+				return l.NextToken()
+			default:
+				tok = token.Token{Type: token.COMMENT, Literal: comment}
+			}
+			tok.Pos, tok.End = start, l.position()
+			return tok
+		}
+		tok = l.readTwoCharToken('=', token.SLASH_ASSIGN, token.SLASH)
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		tok = l.readTwoCharToken('=', token.ASTERISK_ASSIGN, token.ASTERISK)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		tok = l.readTwoCharToken('=', token.LT_EQ, token.LT)
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		tok = l.readTwoCharToken('=', token.GT_EQ, token.GT)
+	case '&':
+		tok = l.readTwoCharToken('&', token.AND, token.ILLEGAL)
+	case '|':
+		tok = l.readTwoCharToken('|', token.OR, token.ILLEGAL)
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
+	case ':':
+		tok = newToken(token.COLON, l.ch)
 	case '(':
 		tok = newToken(token.LPAREN, l.ch)
 	case ')':
@@ -167,10 +450,11 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdent()
 			tok.Type = token.LookUpIdent(tok.Literal)
+			tok.Pos, tok.End = start, l.position()
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readInteger()
+			tok.Type, tok.Literal = l.readNumber()
+			tok.Pos, tok.End = start, l.position()
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
@@ -178,5 +462,6 @@ func (l *Lexer) NextToken() token.Token {
 	}
 
 	l.readChar()
+	tok.Pos, tok.End = start, l.position()
 	return tok
 }
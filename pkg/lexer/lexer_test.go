@@ -16,7 +16,7 @@ x + y;
 
 var result = add(five, ten);
 
-!-/*5;
+!-/ *5;
 
 5 < 10 > 5;
 
@@ -35,6 +35,8 @@ if (5 < 10) {
 "foo bar"
 
 [1, 2];
+
+{"foo": "bar"}
 `
 
 	tests := []struct {
@@ -121,6 +123,304 @@ if (5 < 10) {
 		{token.INT, "2"},
 		{token.RBRACKET, "]"},
 		{token.SEMICOLON, ";"},
+		{token.LBRACE, "{"},
+		{token.STRING, "foo"},
+		{token.COLON, ":"},
+		{token.STRING, "bar"},
+		{token.RBRACE, "}"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - wrong token type. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token literal. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := `var x = 10;
+if (x) {
+	"hi"
+}`
+
+	tests := []struct {
+		expectedType  token.TokenType
+		expectedStart string
+		expectedEnd   string
+		expectedOff   int
+	}{
+		{token.VAR, "1:1", "1:4", 0},
+		{token.IDENT, "1:5", "1:6", 4},
+		{token.ASSIGN, "1:7", "1:8", 6},
+		{token.INT, "1:9", "1:11", 8},
+		{token.SEMICOLON, "1:11", "1:12", 10},
+		{token.IF, "2:1", "2:3", 12},
+		{token.LPAREN, "2:4", "2:5", 15},
+		{token.IDENT, "2:5", "2:6", 16},
+		{token.RPAREN, "2:6", "2:7", 17},
+		{token.LBRACE, "2:8", "2:9", 19},
+		{token.STRING, "3:2", "3:6", 22},
+		{token.RBRACE, "4:1", "4:2", 27},
+		{token.EOF, "4:2", "4:2", 28},
+	}
+
+	l := NewFile("", input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - wrong token type. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos.String() != tt.expectedStart {
+			t.Errorf("tests[%d] - wrong start position. expected=%q, got=%q", i, tt.expectedStart, tok.Pos)
+		}
+
+		if tok.End.String() != tt.expectedEnd {
+			t.Errorf("tests[%d] - wrong end position. expected=%q, got=%q", i, tt.expectedEnd, tok.End)
+		}
+
+		if tok.Pos.Offset != tt.expectedOff {
+			t.Errorf("tests[%d] - wrong offset. expected=%d, got=%d", i, tt.expectedOff, tok.Pos.Offset)
+		}
+	}
+}
+
+func TestTokenPositionFilename(t *testing.T) {
+	l := NewFile("script.mk", "\n  foo")
+
+	tok := l.NextToken()
+	if tok.Pos.String() != "script.mk:2:3" {
+		t.Errorf("wrong position. expected=%q, got=%q", "script.mk:2:3", tok.Pos)
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := `3 3.14 0.5 1e10 2.5E-3 6e+2 5. 7e 1.5e+x x.1`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "3"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "0.5"},
+		{token.FLOAT, "1e10"},
+		{token.FLOAT, "2.5E-3"},
+		{token.FLOAT, "6e+2"},
+		{token.INT, "5"},
+		{token.ILLEGAL, "."},
+		{token.ILLEGAL, "7e"},
+		{token.ILLEGAL, "1.5e+"},
+		{token.IDENT, "x"},
+		{token.IDENT, "x"},
+		{token.ILLEGAL, "."},
+		{token.INT, "1"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - wrong token type. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token literal. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{`"plain"`, token.STRING, "plain"},
+		{`"a\nb\tc\r"`, token.STRING, "a\nb\tc\r"},
+		{`"say \"hi\" \\o/"`, token.STRING, `say "hi" \o/`},
+		{`"\u{48}\u{e9}\u{1F600}"`, token.STRING, "H\u00e9\U0001F600"},
+		{"`raw \\n\nline`", token.STRING, "raw \\n\nline"},
+		{`"bad \q escape"`, token.ILLEGAL, `\q`},
+		{`"\u{110000}"`, token.ILLEGAL, `\u{110000}`},
+		{`"\u{zz}"`, token.ILLEGAL, `\u{`},
+		{`"\u{}"`, token.ILLEGAL, `\u{}`},
+		{`"abc\`, token.ILLEGAL, `"abc\`},
+		{"`abc", token.ILLEGAL, "`abc"},
+	}
+
+	for i, tt := range tests {
+		tok := New(tt.input).NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - wrong token type. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token literal. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestInvalidEscapePosition(t *testing.T) {
+	l := New(`x = "ab\qc" y`)
+
+	l.NextToken()
+	l.NextToken()
+	tok := l.NextToken()
+	if tok.Pos.String() != "1:8" || tok.End.String() != "1:10" {
+		t.Errorf("wrong span. expected=1:8-1:10, got=%s-%s", tok.Pos, tok.End)
+	}
+
+	if tok := l.NextToken(); tok.Type != token.IDENT || tok.Literal != "y" {
+		t.Errorf("lexing didn't resume after the string, got=%q %q", tok.Type, tok.Literal)
+	}
+}
+
+func TestUnterminatedString(t *testing.T) {
+	l := New(`x = "abc`)
+
+	l.NextToken()
+	l.NextToken()
+	tok := l.NextToken()
+	if tok.Type != token.ILLEGAL {
+		t.Fatalf("wrong token type. expected=%q, got=%q", token.ILLEGAL, tok.Type)
+	}
+
+	if tok.Literal != `"abc` {
+		t.Fatalf("wrong token literal. expected=%q, got=%q", `"abc`, tok.Literal)
+	}
+
+	if tok := l.NextToken(); tok.Type != token.EOF {
+		t.Fatalf("wrong token type. expected=%q, got=%q", token.EOF, tok.Type)
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading comment
+var x = 10 / 2; // trailing
+/* block /* nested */ still comment */ x
+/* unterminated`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.COMMENT, "// leading comment"},
+		{token.VAR, "var"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "10"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.COMMENT, "// trailing"},
+		{token.COMMENT, "/* block /* nested */ still comment */"},
+		{token.IDENT, "x"},
+		{token.ILLEGAL, "/* unterminated"},
+		{token.EOF, ""},
+	}
+
+	l := NewFileMode("", input, ScanComments)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - wrong token type. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token literal. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+
+	// without ScanComments the comments are skipped
+	l = New(input)
+	for i, tt := range tests {
+		if tt.expectedType == token.COMMENT {
+			continue
+		}
+
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - wrong token type. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := "var ñame = \"🐒 monkey\"; π2 + ñame;\n_x ¬"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedPos     string
+	}{
+		{token.VAR, "var", "1:1"},
+		{token.IDENT, "ñame", "1:5"},
+		{token.ASSIGN, "=", "1:10"},
+		{token.STRING, "🐒 monkey", "1:12"},
+		{token.SEMICOLON, ";", "1:22"},
+		{token.IDENT, "π2", "1:24"},
+		{token.PLUS, "+", "1:27"},
+		{token.IDENT, "ñame", "1:29"},
+		{token.SEMICOLON, ";", "1:33"},
+		{token.IDENT, "_x", "2:1"},
+		{token.ILLEGAL, "¬", "2:4"},
+		{token.EOF, "", "2:5"},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - wrong token type. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token literal. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Pos.String() != tt.expectedPos {
+			t.Fatalf("tests[%d] - wrong position. expected=%q, got=%q", i, tt.expectedPos, tok.Pos)
+		}
+	}
+}
+
+func TestTwoCharOperators(t *testing.T) {
+	input := `a <= b >= c && d || e < f > g & h | i += -= *= /=`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.AND, "&&"},
+		{token.IDENT, "d"},
+		{token.OR, "||"},
+		{token.IDENT, "e"},
+		{token.LT, "<"},
+		{token.IDENT, "f"},
+		{token.GT, ">"},
+		{token.IDENT, "g"},
+		{token.ILLEGAL, "&"},
+		{token.IDENT, "h"},
+		{token.ILLEGAL, "|"},
+		{token.IDENT, "i"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.MINUS_ASSIGN, "-="},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.SLASH_ASSIGN, "/="},
 		{token.EOF, ""},
 	}
 
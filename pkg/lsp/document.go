@@ -0,0 +1,385 @@
+package lsp
+
+import (
+	"monkey/pkg/ast"
+	"monkey/pkg/lexer"
+	"monkey/pkg/object"
+	"monkey/pkg/parser"
+	"sort"
+	"strings"
+)
+
+// document is an open text document along with what the server knows about
+// the program in it.
+type document struct {
+	uri     string
+	text    string
+	lines   []int // offsets of the first byte of every line
+	program *ast.Program
+	errors  []parser.Diagnostic
+
+	// idents are the identifiers of the program in source order, and
+	// bindings tells which binding each of them refers to. Identifiers
+	// that don't refer to a binding of the program, like builtins, aren't in
+	// bindings.
+	idents   []*ast.Identifier
+	bindings map[*ast.Identifier]*binding
+}
+
+type bindingKind int
+
+const (
+	varBinding   bindingKind = iota // var x = value
+	paramBinding                    // fn(x) { }
+	loopBinding                     // for (x in iterable) { }
+)
+
+// binding is a name introduced by the program.
+type binding struct {
+	kind  bindingKind
+	name  *ast.Identifier
+	value ast.Expression       // the value of a var, the iterable of a loop
+	fn    *ast.FunctionLiteral // the function declaring a parameter
+	refs  []*ast.Identifier    // the declaration and the uses, in source order
+	from  int                  // the offset from which the binding is in scope
+}
+
+func newDocument(uri, text string) *document {
+	d := &document{uri: uri, text: text, bindings: map[*ast.Identifier]*binding{}}
+
+	d.lines = []int{0}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+
+	p := parser.New(lexer.NewFile(uri, text))
+	d.program = p.ParseProgram()
+	d.errors = p.Errors()
+
+	d.resolve()
+	return d
+}
+
+// scope holds the bindings of a function, or of the program for the
+// outermost scope. Blocks don't introduce scopes in Monkey, except for the
+// body of a for loop whose scope only holds the variable of the loop.
+type scope struct {
+	outer *scope
+	names map[string][]*binding
+	loop  bool
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{outer: outer, names: map[string][]*binding{}}
+}
+
+// lookup returns the binding name refers to at offset, used in the scope s.
+// In the scopes of the function using name, that is the last binding in scope
+// at offset, a var only coming into scope after its value. In the outer
+// scopes, it is the last one declared before offset, or the first one if they
+// are all declared later, as functions can refer to globals declared after
+// them.
+func (s *scope) lookup(name string, offset int) *binding {
+	for inner := true; s != nil; s, inner = s.outer, inner && s.loop {
+		candidates := s.names[name]
+
+		var b *binding
+		for _, c := range candidates {
+			if inner && c.from <= offset || !inner && c.name.Pos().Offset <= offset {
+				b = c
+			}
+		}
+		if b == nil && !inner && len(candidates) > 0 {
+			b = candidates[0]
+		}
+		if b != nil {
+			return b
+		}
+	}
+
+	return nil
+}
+
+type use struct {
+	ident *ast.Identifier
+	scope *scope
+}
+
+// resolver declares the bindings of the program and collects the uses of
+// names, which are resolved once all bindings are known.
+type resolver struct {
+	doc   *document
+	scope *scope
+	uses  *[]use
+}
+
+func (r *resolver) Visit(node ast.Node) ast.Visitor {
+	switch n := node.(type) {
+	case *ast.VarStatement:
+		r.declare(&binding{kind: varBinding, name: n.Name, value: n.Value, from: n.End().Offset})
+	case *ast.ForStatement:
+		from := n.Variable.End().Offset
+		if n.Iterable != nil {
+			from = n.Iterable.End().Offset
+		}
+		inner := &resolver{doc: r.doc, scope: newScope(r.scope), uses: r.uses}
+		inner.scope.loop = true
+		inner.declare(&binding{kind: loopBinding, name: n.Variable, value: n.Iterable, from: from})
+		return inner
+	case *ast.FunctionLiteral:
+		inner := &resolver{doc: r.doc, scope: newScope(r.scope), uses: r.uses}
+		for _, param := range n.Parameters {
+			inner.declare(&binding{kind: paramBinding, name: param, fn: n, from: param.Pos().Offset})
+		}
+		return inner
+	case *ast.Identifier:
+		r.doc.idents = append(r.doc.idents, n)
+		if _, declared := r.doc.bindings[n]; !declared {
+			*r.uses = append(*r.uses, use{ident: n, scope: r.scope})
+		}
+	}
+
+	return r
+}
+
+func (r *resolver) declare(b *binding) {
+	if b.name == nil {
+		return
+	}
+
+	// a var declared in a loop outlives it
+	s := r.scope
+	for b.kind == varBinding && s.loop {
+		s = s.outer
+	}
+
+	b.refs = []*ast.Identifier{b.name}
+	s.names[b.name.Value] = append(s.names[b.name.Value], b)
+	r.doc.bindings[b.name] = b
+}
+
+func (d *document) resolve() {
+	var uses []use
+	ast.Walk(&resolver{doc: d, scope: newScope(nil), uses: &uses}, d.program)
+
+	for _, u := range uses {
+		b := u.scope.lookup(u.ident.Value, u.ident.Pos().Offset)
+		if b == nil {
+			continue
+		}
+
+		d.bindings[u.ident] = b
+		b.refs = append(b.refs, u.ident)
+	}
+
+	for _, ident := range d.idents {
+		if b, ok := d.bindings[ident]; ok && b.name == ident {
+			sort.SliceStable(b.refs, func(i, j int) bool {
+				return b.refs[i].Pos().Offset < b.refs[j].Pos().Offset
+			})
+		}
+	}
+}
+
+// identAt returns the identifier at offset, which may also be right after
+// its end, or nil.
+func (d *document) identAt(offset int) *ast.Identifier {
+	for _, ident := range d.idents {
+		if ident.Pos().Offset <= offset && offset <= ident.End().Offset {
+			return ident
+		}
+	}
+
+	return nil
+}
+
+// position converts a byte offset into an LSP position.
+func (d *document) position(offset int) Position {
+	if offset > len(d.text) {
+		offset = len(d.text)
+	}
+
+	line := sort.Search(len(d.lines), func(i int) bool { return d.lines[i] > offset }) - 1
+	character := 0
+	for _, r := range d.text[d.lines[line]:offset] {
+		character += utf16Len(r)
+	}
+
+	return Position{Line: line, Character: character}
+}
+
+// offset converts an LSP position into a byte offset, clamping positions
+// past the end of their line or of the document.
+func (d *document) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(d.lines) {
+		return len(d.text)
+	}
+
+	start := d.lines[pos.Line]
+	character := 0
+	for i, r := range d.text[start:] {
+		if character >= pos.Character || r == '\n' {
+			return start + i
+		}
+		character += utf16Len(r)
+	}
+
+	return len(d.text)
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+
+	return 1
+}
+
+func (d *document) rangeOf(node ast.Node) Range {
+	return d.span(node.Pos().Offset, node.End().Offset)
+}
+
+func (d *document) span(start, end int) Range {
+	if end < start {
+		end = start
+	}
+
+	return Range{Start: d.position(start), End: d.position(end)}
+}
+
+func (d *document) location(node ast.Node) Location {
+	return Location{URI: d.uri, Range: d.rangeOf(node)}
+}
+
+// source returns the text of node in the document.
+func (d *document) source(node ast.Node) string {
+	start, end := node.Pos().Offset, node.End().Offset
+	if start < 0 || end > len(d.text) || end < start {
+		return node.String()
+	}
+
+	return d.text[start:end]
+}
+
+func (d *document) diagnostics() []Diagnostic {
+	diagnostics := make([]Diagnostic, len(d.errors))
+	for i, e := range d.errors {
+		severity := SeverityError
+		if e.Severity == parser.SeverityWarning {
+			severity = SeverityWarning
+		}
+
+		message := e.Message
+		if e.Hint != "" {
+			message += "\nhint: " + e.Hint
+		}
+
+		end := e.End.Offset
+		if !e.End.IsValid() {
+			end = e.Pos.Offset
+		}
+
+		diagnostics[i] = Diagnostic{
+			Range:    d.span(e.Pos.Offset, end),
+			Severity: severity,
+			Source:   "monkey",
+			Message:  message,
+		}
+	}
+
+	return diagnostics
+}
+
+// symbols returns the var bindings in node, with the bindings declared in
+// their values as children.
+func (d *document) symbols(node ast.Node) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	ast.Inspect(node, func(n ast.Node) bool {
+		v, ok := n.(*ast.VarStatement)
+		if !ok {
+			return true
+		}
+		if v.Name == nil {
+			return false
+		}
+
+		symbol := DocumentSymbol{
+			Name:           v.Name.Value,
+			Kind:           SymbolVariable,
+			Range:          d.rangeOf(v),
+			SelectionRange: d.rangeOf(v.Name),
+		}
+		if fn, ok := v.Value.(*ast.FunctionLiteral); ok {
+			symbol.Kind = SymbolFunction
+			symbol.Detail = signature(fn)
+		}
+		if v.Value != nil {
+			if children := d.symbols(v.Value); len(children) > 0 {
+				symbol.Children = children
+			}
+		}
+
+		symbols = append(symbols, symbol)
+		return false
+	})
+
+	return symbols
+}
+
+// hover describes the binding ident refers to in Markdown.
+func (d *document) hover(ident *ast.Identifier) string {
+	b, ok := d.bindings[ident]
+	if !ok {
+		if builtin := object.GetBuiltinByName(ident.Value); builtin != nil {
+			return code("builtin " + ident.Value)
+		}
+		return ""
+	}
+
+	switch b.kind {
+	case paramBinding:
+		return code(b.name.Value) + "\nparameter of `" + signature(b.fn) + "`"
+	case loopBinding:
+		return code("for (" + b.name.Value + " in " + firstLine(d.source(b.value)) + ")")
+	default:
+		if fn, ok := b.value.(*ast.FunctionLiteral); ok {
+			return code(signature(fn))
+		}
+		if b.value == nil {
+			return code("var " + b.name.Value)
+		}
+		return code("var " + b.name.Value + " = " + firstLine(d.source(b.value)))
+	}
+}
+
+// signature returns the name and parameters of fn, like "fn add(a, b)".
+func signature(fn *ast.FunctionLiteral) string {
+	params := make([]string, len(fn.Parameters))
+	for i, param := range fn.Parameters {
+		params[i] = param.Value
+	}
+
+	name := "fn"
+	if fn.Name != "" {
+		name += " " + fn.Name
+	}
+
+	return name + "(" + strings.Join(params, ", ") + ")"
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return strings.TrimRight(s[:i], " \t\r") + " …"
+	}
+
+	return s
+}
+
+func code(s string) string {
+	return "```monkey\n" + s + "\n```"
+}
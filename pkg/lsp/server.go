@@ -0,0 +1,333 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"monkey/pkg/format"
+)
+
+// Serve runs a language server for Monkey, reading the messages of a client
+// from in and writing the replies to out, usually the standard input and
+// output of the process. It returns once the client sends the exit
+// notification or closes in.
+//
+// Documents are synchronized in full on every change. The server publishes
+// the parse errors of the open documents as diagnostics and answers requests
+// for definitions, references, hovers, document symbols and formatting.
+func Serve(in io.Reader, out io.Writer) error {
+	s := &server{
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: map[string]*document{},
+	}
+
+	return s.run()
+}
+
+type server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	docs        map[string]*document
+	initialized bool
+	shutdown    bool
+}
+
+// errExit stops the server once the client asks it to exit.
+var errExit = errors.New("exit")
+
+func (s *server) run() error {
+	for {
+		content, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := s.dispatch(content); err != nil {
+			if err == errExit {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// dispatch handles a message, answering it when it is a request. The error
+// returned is the one writing the answer, failed requests are answered with
+// an error response instead.
+func (s *server) dispatch(content []byte) error {
+	var msg message
+	if err := json.Unmarshal(content, &msg); err != nil {
+		return s.reply(json.RawMessage("null"), nil, errorf(codeParseError, "invalid message: %s", err))
+	}
+
+	if msg.ID == nil {
+		return s.notify(msg.Method, msg.Params)
+	}
+
+	result, rpcErr := s.handle(msg.Method, msg.Params)
+	return s.reply(msg.ID, result, rpcErr)
+}
+
+func (s *server) reply(id json.RawMessage, result interface{}, rpcErr *Error) error {
+	if rpcErr != nil {
+		return writeMessage(s.out, errorResponse{JSONRPC: "2.0", ID: id, Error: rpcErr})
+	}
+
+	return writeMessage(s.out, response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+// handle answers a request.
+func (s *server) handle(method string, params json.RawMessage) (result interface{}, rpcErr *Error) {
+	defer func() {
+		// a bug in the analysis of a document must not bring the editor's
+		// server down
+		if r := recover(); r != nil {
+			result, rpcErr = nil, errorf(codeInternalError, "internal error: %v", r)
+		}
+	}()
+
+	switch {
+	case method == "initialize":
+		s.initialized = true
+		return InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync:           syncFull,
+				DefinitionProvider:         true,
+				ReferencesProvider:         true,
+				HoverProvider:              true,
+				DocumentSymbolProvider:     true,
+				DocumentFormattingProvider: true,
+			},
+			ServerInfo: ServerInfo{Name: "monkey"},
+		}, nil
+	case !s.initialized:
+		return nil, errorf(codeServerNotInitialized, "server not initialized")
+	case s.shutdown:
+		return nil, errorf(codeInvalidRequest, "server is shutting down")
+	}
+
+	switch method {
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/definition":
+		var p TextDocumentPositionParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.definition(p)
+
+	case "textDocument/references":
+		var p ReferenceParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.references(p)
+
+	case "textDocument/hover":
+		var p TextDocumentPositionParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.hover(p)
+
+	case "textDocument/documentSymbol":
+		var p DocumentSymbolParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, invalidParams(err)
+		}
+		doc, rpcErr := s.document(p.TextDocument.URI)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		return doc.symbols(doc.program), nil
+
+	case "textDocument/formatting":
+		var p DocumentFormattingParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.formatting(p)
+
+	default:
+		return nil, errorf(codeMethodNotFound, "method not supported: %s", method)
+	}
+}
+
+func invalidParams(err error) *Error {
+	return errorf(codeInvalidParams, "invalid params: %s", err)
+}
+
+// notify handles a notification, which is never answered. It returns errExit
+// when the client asks the server to exit.
+func (s *server) notify(method string, params json.RawMessage) error {
+	switch method {
+	case "exit":
+		return errExit
+
+	case "textDocument/didOpen":
+		var p DidOpenTextDocumentParams
+		if json.Unmarshal(params, &p) != nil {
+			return nil
+		}
+		return s.update(p.TextDocument.URI, p.TextDocument.Text)
+
+	case "textDocument/didChange":
+		var p DidChangeTextDocumentParams
+		if json.Unmarshal(params, &p) != nil || len(p.ContentChanges) == 0 {
+			return nil
+		}
+		return s.update(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+
+	case "textDocument/didClose":
+		var p DidCloseTextDocumentParams
+		if json.Unmarshal(params, &p) != nil {
+			return nil
+		}
+		delete(s.docs, p.TextDocument.URI)
+		return s.publishDiagnostics(p.TextDocument.URI, []Diagnostic{})
+	}
+
+	// initialized and notifications the server doesn't support are ignored
+	return nil
+}
+
+// update analyzes the new text of a document and publishes its diagnostics.
+// When the analysis fails, the server keeps the last document it analyzed and
+// publishes the failure instead.
+func (s *server) update(uri, text string) error {
+	doc, diagnostics, err := analyzeSafely(uri, text)
+	if err != nil {
+		return s.publishDiagnostics(uri, []Diagnostic{{
+			Severity: SeverityError,
+			Source:   "monkey",
+			Message:  err.Error(),
+		}})
+	}
+
+	s.docs[uri] = doc
+	return s.publishDiagnostics(uri, diagnostics)
+}
+
+// analyze builds what the server knows about a document, tests replace it to
+// make the analysis fail.
+var analyze = newDocument
+
+// analyzeSafely analyzes a document, turning a panic of the analysis into an
+// error so that a bug doesn't bring the editor's server down.
+func analyzeSafely(uri, text string) (doc *document, diagnostics []Diagnostic, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			doc, diagnostics, err = nil, nil, fmt.Errorf("internal error: %v", r)
+		}
+	}()
+
+	doc = analyze(uri, text)
+	return doc, doc.diagnostics(), nil
+}
+
+func (s *server) publishDiagnostics(uri string, diagnostics []Diagnostic) error {
+	return writeMessage(s.out, notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  PublishDiagnosticsParams{URI: uri, Diagnostics: diagnostics},
+	})
+}
+
+func (s *server) document(uri string) (*document, *Error) {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil, errorf(codeRequestFailed, "document not open: %s", uri)
+	}
+
+	return doc, nil
+}
+
+// binding returns the document at p and the binding of the identifier at the
+// position, if any.
+func (s *server) binding(p TextDocumentPositionParams) (*document, *binding, *Error) {
+	doc, rpcErr := s.document(p.TextDocument.URI)
+	if rpcErr != nil {
+		return nil, nil, rpcErr
+	}
+
+	ident := doc.identAt(doc.offset(p.Position))
+	if ident == nil {
+		return doc, nil, nil
+	}
+
+	return doc, doc.bindings[ident], nil
+}
+
+func (s *server) definition(p TextDocumentPositionParams) (interface{}, *Error) {
+	doc, b, rpcErr := s.binding(p)
+	if rpcErr != nil || b == nil {
+		return nil, rpcErr
+	}
+
+	return doc.location(b.name), nil
+}
+
+func (s *server) references(p ReferenceParams) (interface{}, *Error) {
+	doc, b, rpcErr := s.binding(p.TextDocumentPositionParams)
+	if rpcErr != nil || b == nil {
+		return nil, rpcErr
+	}
+
+	locations := []Location{}
+	for _, ref := range b.refs {
+		if ref != b.name || p.Context.IncludeDeclaration {
+			locations = append(locations, doc.location(ref))
+		}
+	}
+
+	return locations, nil
+}
+
+func (s *server) hover(p TextDocumentPositionParams) (interface{}, *Error) {
+	doc, rpcErr := s.document(p.TextDocument.URI)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	ident := doc.identAt(doc.offset(p.Position))
+	if ident == nil {
+		return nil, nil
+	}
+
+	text := doc.hover(ident)
+	if text == "" {
+		return nil, nil
+	}
+
+	r := doc.rangeOf(ident)
+	return Hover{Contents: MarkupContent{Kind: "markdown", Value: text}, Range: &r}, nil
+}
+
+// formatting replaces the whole document with its formatted text. A document
+// that can't be parsed isn't formatted.
+func (s *server) formatting(p DocumentFormattingParams) (interface{}, *Error) {
+	doc, rpcErr := s.document(p.TextDocument.URI)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	formatted, err := format.Source(doc.uri, []byte(doc.text))
+	if err != nil {
+		return nil, errorf(codeRequestFailed, "cannot format a document with errors: %s", err)
+	}
+
+	if string(formatted) == doc.text {
+		return []TextEdit{}, nil
+	}
+
+	return []TextEdit{{Range: doc.span(0, len(doc.text)), NewText: string(formatted)}}, nil
+}
@@ -0,0 +1,507 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"monkey/pkg/format"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// client talks to a server running in the same process over pipes, the way
+// an editor talks to it over stdio.
+type client struct {
+	t        *testing.T
+	w        *io.PipeWriter
+	messages chan message
+	done     chan error
+	nextID   int
+}
+
+// newClient starts a server and initializes it.
+func newClient(t *testing.T) *client {
+	t.Helper()
+
+	c := startServer(t)
+	c.call("initialize", map[string]interface{}{"capabilities": map[string]interface{}{}}, nil)
+	c.notify("initialized", struct{}{})
+
+	return c
+}
+
+// startServer starts a server and returns a client connected to it.
+func startServer(t *testing.T) *client {
+	t.Helper()
+
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+
+	c := &client{t: t, w: clientOut, messages: make(chan message, 16), done: make(chan error, 1)}
+
+	go func() {
+		c.done <- Serve(serverIn, serverOut)
+		serverOut.Close()
+	}()
+
+	go func() {
+		r := bufio.NewReader(clientIn)
+		for {
+			content, err := readMessage(r)
+			if err != nil {
+				close(c.messages)
+				return
+			}
+
+			var msg message
+			if err := json.Unmarshal(content, &msg); err != nil {
+				t.Errorf("server sent invalid JSON %q: %s", content, err)
+			}
+			c.messages <- msg
+		}
+	}()
+
+	t.Cleanup(func() { clientOut.Close() })
+
+	return c
+}
+
+func (c *client) send(v interface{}) {
+	c.t.Helper()
+
+	if err := writeMessage(c.w, v); err != nil {
+		c.t.Fatalf("writing message: %s", err)
+	}
+}
+
+func (c *client) notify(method string, params interface{}) {
+	c.t.Helper()
+	c.send(notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// receive returns the next message of the server.
+func (c *client) receive() message {
+	c.t.Helper()
+
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			c.t.Fatal("server closed the connection")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out waiting for the server")
+	}
+
+	return message{}
+}
+
+// request sends a request and returns the response, failing the test on
+// notifications received meanwhile.
+func (c *client) request(method string, params interface{}) message {
+	c.t.Helper()
+
+	c.nextID++
+	id := json.RawMessage(strconv.Itoa(c.nextID))
+	c.send(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Method  string          `json:"method"`
+		Params  interface{}     `json:"params"`
+	}{"2.0", id, method, params})
+
+	msg := c.receive()
+	if string(msg.ID) != string(id) {
+		c.t.Fatalf("expected the response to request %s, got %+v", id, msg)
+	}
+
+	return msg
+}
+
+// call sends a request and decodes its result into result, which may be nil.
+func (c *client) call(method string, params interface{}, result interface{}) {
+	c.t.Helper()
+
+	msg := c.request(method, params)
+	if msg.Error != nil {
+		c.t.Fatalf("%s failed: %s", method, msg.Error)
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(msg.Result, result); err != nil {
+			c.t.Fatalf("decoding the result of %s %s: %s", method, msg.Result, err)
+		}
+	}
+}
+
+// open opens a document and returns the diagnostics the server publishes.
+func (c *client) open(uri, text string) []Diagnostic {
+	c.t.Helper()
+
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: uri, LanguageID: "monkey", Version: 1, Text: text},
+	})
+	return c.diagnostics(uri)
+}
+
+func (c *client) diagnostics(uri string) []Diagnostic {
+	c.t.Helper()
+
+	msg := c.receive()
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("expected diagnostics, got %+v", msg)
+	}
+
+	var params PublishDiagnosticsParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		c.t.Fatalf("decoding diagnostics: %s", err)
+	}
+	if params.URI != uri {
+		c.t.Fatalf("diagnostics for the wrong document. want=%s, got=%s", uri, params.URI)
+	}
+
+	return params.Diagnostics
+}
+
+func at(uri string, line, character int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: line, Character: character},
+	}
+}
+
+func span(startLine, startChar, endLine, endChar int) Range {
+	return Range{Start: Position{startLine, startChar}, End: Position{endLine, endChar}}
+}
+
+const uri = "file:///test.mk"
+
+const program = `var add = fn(a, b) {
+  var sum = a + b;
+  sum
+};
+var x = add(1, 2);
+for (i in [x]) { puts(i) }
+`
+
+func TestInitialize(t *testing.T) {
+	c := startServer(t)
+
+	msg := c.request("textDocument/hover", at(uri, 0, 0))
+	if msg.Error == nil || msg.Error.Code != codeServerNotInitialized {
+		t.Errorf("expected a server not initialized error, got %+v", msg)
+	}
+
+	var result InitializeResult
+	c.call("initialize", map[string]interface{}{}, &result)
+
+	expected := ServerCapabilities{
+		TextDocumentSync:           syncFull,
+		DefinitionProvider:         true,
+		ReferencesProvider:         true,
+		HoverProvider:              true,
+		DocumentSymbolProvider:     true,
+		DocumentFormattingProvider: true,
+	}
+	if result.Capabilities != expected {
+		t.Errorf("wrong capabilities. want=%+v, got=%+v", expected, result.Capabilities)
+	}
+	if result.ServerInfo.Name != "monkey" {
+		t.Errorf("wrong server name %q", result.ServerInfo.Name)
+	}
+}
+
+func TestShutdownAndExit(t *testing.T) {
+	c := newClient(t)
+
+	msg := c.request("shutdown", nil)
+	if msg.Error != nil || string(msg.Result) != "null" {
+		t.Errorf("wrong shutdown response %+v", msg)
+	}
+
+	msg = c.request("textDocument/hover", at(uri, 0, 0))
+	if msg.Error == nil || msg.Error.Code != codeInvalidRequest {
+		t.Errorf("expected requests to fail after shutdown, got %+v", msg)
+	}
+
+	c.notify("exit", nil)
+	select {
+	case err := <-c.done:
+		if err != nil {
+			t.Errorf("Serve returned error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not exit")
+	}
+}
+
+func TestUnknownMethod(t *testing.T) {
+	c := newClient(t)
+
+	msg := c.request("textDocument/rename", at(uri, 0, 0))
+	if msg.Error == nil || msg.Error.Code != codeMethodNotFound {
+		t.Errorf("expected a method not found error, got %+v", msg)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	c := newClient(t)
+
+	diagnostics := c.open(uri, "var x = 1;\nvar = 2;")
+	expected := []Diagnostic{{
+		Range:    span(1, 4, 1, 5),
+		Severity: SeverityError,
+		Source:   "monkey",
+		Message:  "expected next token to be IDENT, got== instead",
+	}}
+	if !reflect.DeepEqual(diagnostics, expected) {
+		t.Errorf("wrong diagnostics.\nwant=%+v\ngot= %+v", expected, diagnostics)
+	}
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   TextDocumentIdentifier{URI: uri},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "var x = (1;"}},
+	})
+	diagnostics = c.diagnostics(uri)
+	if len(diagnostics) != 1 || diagnostics[0].Message != "expected next token to be ), got=; instead\nhint: insert a closing \")\"" {
+		t.Errorf("wrong diagnostics after change: %+v", diagnostics)
+	}
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   TextDocumentIdentifier{URI: uri},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "var x = 1;"}},
+	})
+	if diagnostics := c.diagnostics(uri); len(diagnostics) != 0 {
+		t.Errorf("expected no diagnostics, got %+v", diagnostics)
+	}
+
+	c.notify("textDocument/didClose", DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: uri}})
+	if diagnostics := c.diagnostics(uri); len(diagnostics) != 0 {
+		t.Errorf("expected diagnostics to be cleared, got %+v", diagnostics)
+	}
+
+	msg := c.request("textDocument/hover", at(uri, 0, 4))
+	if msg.Error == nil || msg.Error.Code != codeRequestFailed {
+		t.Errorf("expected requests on closed documents to fail, got %+v", msg)
+	}
+}
+
+func TestAnalysisPanic(t *testing.T) {
+	c := newClient(t)
+	c.open(uri, program)
+
+	analyze = func(uri, text string) *document { panic("bug") }
+	defer func() { analyze = newDocument }()
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   TextDocumentIdentifier{URI: uri},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "var y = 2;"}},
+	})
+	diagnostics := c.diagnostics(uri)
+	expected := []Diagnostic{{Severity: SeverityError, Source: "monkey", Message: "internal error: bug"}}
+	if !reflect.DeepEqual(diagnostics, expected) {
+		t.Errorf("wrong diagnostics.\nwant=%+v\ngot= %+v", expected, diagnostics)
+	}
+
+	// the last analysis is still used
+	var location *Location
+	c.call("textDocument/definition", at(uri, 4, 9), &location)
+	if location == nil || location.Range != span(0, 4, 0, 7) {
+		t.Errorf("wrong definition after a failed analysis: %+v", location)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	c := newClient(t)
+	c.open(uri, program)
+
+	tests := []struct {
+		line, character int
+		expected        *Range
+	}{
+		{1, 12, &Range{Start: Position{0, 13}, End: Position{0, 14}}}, // a in a + b
+		{1, 16, &Range{Start: Position{0, 16}, End: Position{0, 17}}}, // b in a + b
+		{2, 3, &Range{Start: Position{1, 6}, End: Position{1, 9}}},    // sum, right after its end
+		{4, 9, &Range{Start: Position{0, 4}, End: Position{0, 7}}},    // add
+		{5, 11, &Range{Start: Position{4, 4}, End: Position{4, 5}}},   // x
+		{5, 22, &Range{Start: Position{5, 5}, End: Position{5, 6}}},   // i
+		{0, 4, &Range{Start: Position{0, 4}, End: Position{0, 7}}},    // the declaration itself
+		{5, 17, nil}, // puts is a builtin
+		{3, 0, nil},  // no identifier
+	}
+
+	for _, tt := range tests {
+		var location *Location
+		c.call("textDocument/definition", at(uri, tt.line, tt.character), &location)
+
+		if tt.expected == nil {
+			if location != nil {
+				t.Errorf("%d:%d: expected no definition, got %+v", tt.line, tt.character, location)
+			}
+			continue
+		}
+
+		if location == nil || location.URI != uri || location.Range != *tt.expected {
+			t.Errorf("%d:%d: wrong definition. want=%+v, got=%+v", tt.line, tt.character, tt.expected, location)
+		}
+	}
+}
+
+func TestDefinitionScopes(t *testing.T) {
+	c := newClient(t)
+	c.open(uri, "var f = fn(x) { g(x) };\nvar g = fn(x) { var f = x; f };\nvar s = \"😀\"; s\nvar n = 1;\nvar n = n + 1; n\nvar y = 1;\nfor (y in [y]) { var z = y }; [y, z]")
+
+	tests := []struct {
+		line, character int
+		expected        Range
+	}{
+		{0, 16, span(1, 4, 1, 5)},   // g is declared after its use
+		{0, 18, span(0, 11, 0, 12)}, // the parameter of f, not of g
+		{1, 27, span(1, 20, 1, 21)}, // the local f shadows the global one
+		{2, 15, span(2, 4, 2, 5)},   // the emoji takes two UTF-16 code units
+		{4, 8, span(3, 4, 3, 5)},    // the value refers to the n it shadows
+		{4, 15, span(4, 4, 4, 5)},   // the n declared with that value
+		{6, 11, span(5, 4, 5, 5)},   // the iterable is outside of the loop
+		{6, 25, span(6, 5, 6, 6)},   // the variable of the loop
+		{6, 31, span(5, 4, 5, 5)},   // the variable is gone after the loop
+		{6, 34, span(6, 21, 6, 22)}, // a var outlives the loop
+	}
+
+	for _, tt := range tests {
+		var location Location
+		c.call("textDocument/definition", at(uri, tt.line, tt.character), &location)
+
+		if location.Range != tt.expected {
+			t.Errorf("%d:%d: wrong definition. want=%+v, got=%+v", tt.line, tt.character, tt.expected, location.Range)
+		}
+	}
+}
+
+func TestReferences(t *testing.T) {
+	c := newClient(t)
+	c.open(uri, program)
+
+	params := ReferenceParams{TextDocumentPositionParams: at(uri, 1, 12)}
+	var locations []Location
+	c.call("textDocument/references", params, &locations)
+
+	expected := []Location{{URI: uri, Range: span(1, 12, 1, 13)}}
+	if !reflect.DeepEqual(locations, expected) {
+		t.Errorf("wrong references.\nwant=%+v\ngot= %+v", expected, locations)
+	}
+
+	params = ReferenceParams{TextDocumentPositionParams: at(uri, 4, 9), Context: ReferenceContext{IncludeDeclaration: true}}
+	c.call("textDocument/references", params, &locations)
+
+	expected = []Location{{URI: uri, Range: span(0, 4, 0, 7)}, {URI: uri, Range: span(4, 8, 4, 11)}}
+	if !reflect.DeepEqual(locations, expected) {
+		t.Errorf("wrong references.\nwant=%+v\ngot= %+v", expected, locations)
+	}
+}
+
+func TestHover(t *testing.T) {
+	c := newClient(t)
+	c.open(uri, program+"var long = [\n  1\n];\nlong")
+
+	tests := []struct {
+		line, character int
+		expected        string
+	}{
+		{4, 9, "```monkey\nfn add(a, b)\n```"},
+		{5, 11, "```monkey\nvar x = add(1, 2)\n```"},
+		{1, 16, "```monkey\nb\n```\nparameter of `fn add(a, b)`"},
+		{5, 22, "```monkey\nfor (i in [x])\n```"},
+		{5, 17, "```monkey\nbuiltin puts\n```"},
+		{9, 0, "```monkey\nvar long = [ …\n```"},
+	}
+
+	for _, tt := range tests {
+		var hover Hover
+		c.call("textDocument/hover", at(uri, tt.line, tt.character), &hover)
+
+		if hover.Contents.Kind != "markdown" || hover.Contents.Value != tt.expected {
+			t.Errorf("%d:%d: wrong hover. want=%q, got=%q", tt.line, tt.character, tt.expected, hover.Contents.Value)
+		}
+	}
+
+	msg := c.request("textDocument/hover", at(uri, 3, 0))
+	if string(msg.Result) != "null" {
+		t.Errorf("expected no hover outside identifiers, got %s", msg.Result)
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	c := newClient(t)
+	c.open(uri, program)
+
+	var symbols []DocumentSymbol
+	c.call("textDocument/documentSymbol", DocumentSymbolParams{TextDocument: TextDocumentIdentifier{URI: uri}}, &symbols)
+
+	expected := []DocumentSymbol{
+		{
+			Name:           "add",
+			Detail:         "fn add(a, b)",
+			Kind:           SymbolFunction,
+			Range:          span(0, 0, 3, 1),
+			SelectionRange: span(0, 4, 0, 7),
+			Children: []DocumentSymbol{{
+				Name:           "sum",
+				Kind:           SymbolVariable,
+				Range:          span(1, 2, 1, 17),
+				SelectionRange: span(1, 6, 1, 9),
+			}},
+		},
+		{
+			Name:           "x",
+			Kind:           SymbolVariable,
+			Range:          span(4, 0, 4, 17),
+			SelectionRange: span(4, 4, 4, 5),
+		},
+	}
+	if !reflect.DeepEqual(symbols, expected) {
+		t.Errorf("wrong symbols.\nwant=%+v\ngot= %+v", expected, symbols)
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	c := newClient(t)
+	c.open(uri, program)
+
+	params := DocumentFormattingParams{TextDocument: TextDocumentIdentifier{URI: uri}}
+	var edits []TextEdit
+	c.call("textDocument/formatting", params, &edits)
+
+	formatted, err := format.Source(uri, []byte(program))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []TextEdit{{Range: span(0, 0, 6, 0), NewText: string(formatted)}}
+	if !reflect.DeepEqual(edits, expected) {
+		t.Errorf("wrong edits.\nwant=%+v\ngot= %+v", expected, edits)
+	}
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   TextDocumentIdentifier{URI: uri},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: string(formatted)}},
+	})
+	c.diagnostics(uri)
+
+	c.call("textDocument/formatting", params, &edits)
+	if len(edits) != 0 {
+		t.Errorf("expected no edits for a formatted document, got %+v", edits)
+	}
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   TextDocumentIdentifier{URI: uri},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "var = 1"}},
+	})
+	c.diagnostics(uri)
+
+	msg := c.request("textDocument/formatting", params)
+	if msg.Error == nil || msg.Error.Code != codeRequestFailed {
+		t.Errorf("expected formatting a broken document to fail, got %+v", msg)
+	}
+}
@@ -0,0 +1,700 @@
+package object
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Builtins lists the functions available to every Monkey program. The order
+// is significant: the compiler refers to builtins by their index.
+var Builtins = []struct {
+	Name    string
+	Builtin *Builtin
+}{
+	{
+		Name: "len",
+		Builtin: &Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *String:
+				return &Integer{
+					Value: int64(arg.Len()),
+				}
+			case *Array:
+				return &Integer{
+					Value: int64(len(arg.Elements)),
+				}
+			case *Hash:
+				return &Integer{
+					Value: int64(len(arg.Pairs)),
+				}
+			default:
+				return newError(
+					"argument to `len` not supported, got %s",
+					args[0].Type(),
+				)
+			}
+		}},
+	},
+	{
+		Name: "bytelen",
+		Builtin: &Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			arg, ok := args[0].(*String)
+			if !ok {
+				return newError(
+					"argument to `bytelen` must be STRING, got %s",
+					args[0].Type(),
+				)
+			}
+
+			return &Integer{Value: int64(arg.ByteLen())}
+		}},
+	},
+	{
+		// range(end), range(start, end) or range(start, end, step) returns
+		// the integers from start (0 by default) up to, but not including,
+		// end, at most maxRangeLen of them.
+		Name: "range",
+		Builtin: &Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) < 1 || len(args) > 3 {
+				return newError("wrong number of arguments. got=%d, want=1..3", len(args))
+			}
+
+			bounds := []int64{0, 0, 1}
+			for i, arg := range args {
+				integer, ok := arg.(*Integer)
+				if !ok {
+					return newError("arguments to `range` must be INTEGER, got %s", arg.Type())
+				}
+				bounds[i] = integer.Value
+			}
+
+			start, end, step := bounds[0], bounds[1], bounds[2]
+			if len(args) == 1 {
+				start, end = 0, bounds[0]
+			}
+
+			if step == 0 {
+				return newError("`range` step must not be zero")
+			}
+
+			count, ok := rangeLen(start, end, step)
+			if !ok {
+				return newError("`range` too large, got more than %d elements", maxRangeLen)
+			}
+			if err := rt.Charge(count); err != nil {
+				return err
+			}
+
+			elements := make([]Object, count)
+			for i := range elements {
+				elements[i] = &Integer{Value: start + int64(i)*step}
+			}
+
+			return &Array{Elements: elements}
+		}},
+	},
+	{
+		Name: "first",
+		Builtin: &Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			arr, err := arrayArg("first", args, 1)
+			if err != nil {
+				return err
+			}
+
+			if len(arr.Elements) == 0 {
+				return nil
+			}
+
+			return arr.Elements[0]
+		}},
+	},
+	{
+		Name: "last",
+		Builtin: &Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			arr, err := arrayArg("last", args, 1)
+			if err != nil {
+				return err
+			}
+
+			if len(arr.Elements) == 0 {
+				return nil
+			}
+
+			return arr.Elements[len(arr.Elements)-1]
+		}},
+	},
+	{
+		// rest returns a new array holding every element but the first one,
+		// or null for an empty array.
+		Name: "rest",
+		Builtin: &Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			arr, err := arrayArg("rest", args, 1)
+			if err != nil {
+				return err
+			}
+
+			if len(arr.Elements) == 0 {
+				return nil
+			}
+
+			if err := rt.Charge(len(arr.Elements)); err != nil {
+				return err
+			}
+
+			return &Array{Elements: copyElements(arr.Elements[1:])}
+		}},
+	},
+	{
+		// push returns a new array with the element appended, the array
+		// passed in is left alone.
+		Name: "push",
+		Builtin: &Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			arr, err := arrayArg("push", args, 2)
+			if err != nil {
+				return err
+			}
+
+			if err := rt.Charge(len(arr.Elements) + 1); err != nil {
+				return err
+			}
+
+			elements := copyElements(arr.Elements)
+			return &Array{Elements: append(elements, args[1])}
+		}},
+	},
+	{
+		Name: "concat",
+		Builtin: &Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) < 1 {
+				return newError("wrong number of arguments. got=%d, want=1+", len(args))
+			}
+
+			elements := []Object{}
+			for _, arg := range args {
+				arr, ok := arg.(*Array)
+				if !ok {
+					return newError("arguments to `concat` must be ARRAY, got %s", arg.Type())
+				}
+
+				if err := rt.Charge(len(arr.Elements)); err != nil {
+					return err
+				}
+				elements = append(elements, arr.Elements...)
+			}
+
+			return &Array{Elements: elements}
+		}},
+	},
+	{
+		// slice(x, start) or slice(x, start, end) returns the elements of an
+		// array, or the characters of a string, from start up to but not
+		// including end. Out of range bounds are clamped.
+		Name: "slice",
+		Builtin: &Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) < 2 || len(args) > 3 {
+				return newError("wrong number of arguments. got=%d, want=2..3", len(args))
+			}
+
+			var length int
+			switch arg := args[0].(type) {
+			case *Array:
+				length = len(arg.Elements)
+			case *String:
+				length = arg.Len()
+			default:
+				return newError("argument to `slice` must be ARRAY or STRING, got %s", args[0].Type())
+			}
+
+			bounds := []int{0, length}
+			for i, arg := range args[1:] {
+				integer, ok := arg.(*Integer)
+				if !ok {
+					return newError("bounds of `slice` must be INTEGER, got %s", arg.Type())
+				}
+				bounds[i] = clamp(integer.Value, length)
+			}
+
+			start, end := bounds[0], bounds[1]
+			if end < start {
+				end = start
+			}
+
+			if err := rt.Charge(end - start); err != nil {
+				return err
+			}
+
+			if str, ok := args[0].(*String); ok {
+				return &String{Value: string([]rune(str.Value)[start:end])}
+			}
+
+			return &Array{Elements: copyElements(args[0].(*Array).Elements[start:end])}
+		}},
+	},
+	{
+		Name: "reverse",
+		Builtin: &Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			if err := rt.Charge(lenOf(args[0])); err != nil {
+				return err
+			}
+
+			switch arg := args[0].(type) {
+			case *Array:
+				elements := make([]Object, len(arg.Elements))
+				for i, el := range arg.Elements {
+					elements[len(elements)-1-i] = el
+				}
+				return &Array{Elements: elements}
+			case *String:
+				runes := []rune(arg.Value)
+				for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+					runes[i], runes[j] = runes[j], runes[i]
+				}
+				return &String{Value: string(runes)}
+			default:
+				return newError("argument to `reverse` must be ARRAY or STRING, got %s", args[0].Type())
+			}
+		}},
+	},
+	{
+		// sort(arr) returns a new array with the numbers or the strings of
+		// arr in ascending order. sort(arr, less) orders any elements using
+		// less(a, b), which returns whether a goes before b. The sort is
+		// stable.
+		Name: "sort",
+		Builtin: &Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) < 1 || len(args) > 2 {
+				return newError("wrong number of arguments. got=%d, want=1..2", len(args))
+			}
+
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return newError("argument to `sort` must be ARRAY, got %s", args[0].Type())
+			}
+
+			if err := rt.Charge(len(arr.Elements)); err != nil {
+				return err
+			}
+
+			elements := copyElements(arr.Elements)
+
+			var err *Error
+			less := func(a, b Object) bool {
+				if err != nil {
+					return false
+				}
+
+				var result bool
+				if len(args) == 2 {
+					result, err = callLess(rt, args[1], a, b)
+				} else {
+					result, err = compare(a, b)
+				}
+
+				return result
+			}
+
+			sort.SliceStable(elements, func(i, j int) bool {
+				return less(elements[i], elements[j])
+			})
+
+			if err != nil {
+				return err
+			}
+
+			return &Array{Elements: elements}
+		}},
+	},
+	{
+		// map returns a new array holding the result of fn(x) for every
+		// element x.
+		Name: "map",
+		Builtin: &Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			arr, err := arrayArg("map", args, 2)
+			if err != nil {
+				return err
+			}
+
+			elements := make([]Object, len(arr.Elements))
+			for i, el := range arr.Elements {
+				result := rt.Call(args[1], []Object{el})
+				if isError(result) {
+					return result
+				}
+				elements[i] = result
+			}
+
+			return &Array{Elements: elements}
+		}},
+	},
+	{
+		// filter returns a new array holding the elements x for which fn(x)
+		// is truthy.
+		Name: "filter",
+		Builtin: &Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			arr, err := arrayArg("filter", args, 2)
+			if err != nil {
+				return err
+			}
+
+			elements := []Object{}
+			for _, el := range arr.Elements {
+				result := rt.Call(args[1], []Object{el})
+				if isError(result) {
+					return result
+				}
+
+				if isTruthy(result) {
+					elements = append(elements, el)
+				}
+			}
+
+			return &Array{Elements: elements}
+		}},
+	},
+	{
+		// reduce(arr, initial, fn) folds the elements of arr into a single
+		// value, calling fn(accumulator, x) for every element x.
+		Name: "reduce",
+		Builtin: &Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			arr, err := arrayArg("reduce", args, 3)
+			if err != nil {
+				return err
+			}
+
+			acc := args[1]
+			for _, el := range arr.Elements {
+				acc = rt.Call(args[2], []Object{acc, el})
+				if isError(acc) {
+					return acc
+				}
+			}
+
+			return acc
+		}},
+	},
+	{
+		// puts writes every argument on a line of its own.
+		Name: "puts",
+		Builtin: &Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			var out strings.Builder
+			for _, arg := range args {
+				out.WriteString(arg.Inspect())
+				out.WriteString("\n")
+			}
+
+			return write(rt, "puts", out.String())
+		}},
+	},
+	{
+		// print writes its arguments separated by spaces, without a trailing
+		// newline.
+		Name: "print",
+		Builtin: &Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			values := make([]string, len(args))
+			for i, arg := range args {
+				values[i] = arg.Inspect()
+			}
+
+			return write(rt, "print", strings.Join(values, " "))
+		}},
+	},
+	{
+		// printf writes its arguments formatted like format does.
+		Name: "printf",
+		Builtin: &Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) < 1 {
+				return newError("wrong number of arguments. got=%d, want=1+", len(args))
+			}
+
+			out, err := formatArgs(rt, "printf", args[0], args[1:])
+			if err != nil {
+				return err
+			}
+
+			return write(rt, "printf", out)
+		}},
+	},
+	{
+		// format(format, args...) returns a string built from format, in
+		// which every verb is replaced by the next argument:
+		//
+		//	%s, %v  the argument as puts prints it
+		//	%q      a string argument, quoted
+		//	%d      an integer
+		//	%f      a number, as a float
+		//	%t      a boolean
+		//	%%      a percent sign
+		//
+		// Verbs take flags, a width and a precision like in Go, e.g. %-5d or
+		// %.2f.
+		Name: "format",
+		Builtin: &Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) < 1 {
+				return newError("wrong number of arguments. got=%d, want=1+", len(args))
+			}
+
+			out, err := formatArgs(rt, "format", args[0], args[1:])
+			if err != nil {
+				return err
+			}
+
+			return &String{Value: out}
+		}},
+	},
+}
+
+// GetBuiltinByName returns the builtin with the given name or nil if there is
+// none.
+func GetBuiltinByName(name string) *Builtin {
+	for _, def := range Builtins {
+		if def.Name == name {
+			return def.Builtin
+		}
+	}
+
+	return nil
+}
+
+func newError(format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...)}
+}
+
+// arrayArg checks that a builtin got want arguments, the first of which is an
+// array, and returns that array.
+func arrayArg(name string, args []Object, want int) (*Array, *Error) {
+	if len(args) != want {
+		return nil, newError("wrong number of arguments. got=%d, want=%d", len(args), want)
+	}
+
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return nil, newError("argument to `%s` must be ARRAY, got %s", name, args[0].Type())
+	}
+
+	return arr, nil
+}
+
+// copyElements returns a copy of elements, so that a new array doesn't share
+// its storage with the one it was derived from.
+func copyElements(elements []Object) []Object {
+	return append([]Object{}, elements...)
+}
+
+// lenOf returns the number of elements of an array or the length of a string
+// in bytes, which bounds the work of the builtins going through them.
+func lenOf(obj Object) int {
+	switch obj := obj.(type) {
+	case *Array:
+		return len(obj.Elements)
+	case *String:
+		return len(obj.Value)
+	default:
+		return 0
+	}
+}
+
+// clamp turns i into an index within [0, length].
+func clamp(i int64, length int) int {
+	switch {
+	case i < 0:
+		return 0
+	case i > int64(length):
+		return length
+	default:
+		return int(i)
+	}
+}
+
+// compare reports whether a sorts before b when both are numbers or both are
+// strings.
+func compare(a, b Object) (bool, *Error) {
+	switch {
+	case isNumber(a) && isNumber(b):
+		return toFloat(a) < toFloat(b), nil
+	case a.Type() == STRING_OBJ && b.Type() == STRING_OBJ:
+		return a.(*String).Value < b.(*String).Value, nil
+	default:
+		return false, newError("`sort` can't compare %s and %s, pass a comparison function", a.Type(), b.Type())
+	}
+}
+
+// callLess calls the comparison function passed to sort.
+func callLess(rt Runtime, less, a, b Object) (bool, *Error) {
+	result := rt.Call(less, []Object{a, b})
+	if err, ok := result.(*Error); ok {
+		return false, err
+	}
+
+	boolean, ok := result.(*Boolean)
+	if !ok {
+		return false, newError("comparison function of `sort` must return BOOLEAN, got %s", result.Type())
+	}
+
+	return boolean.Value, nil
+}
+
+func isNumber(obj Object) bool {
+	return obj.Type() == INTEGER_OBJ || obj.Type() == FLOAT_OBJ
+}
+
+func toFloat(obj Object) float64 {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value)
+	case *Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
+// maxRangeLen is the number of elements range refuses to go beyond.
+const maxRangeLen = 1 << 26
+
+// rangeLen returns the number of elements of range(start, end, step), or false
+// when it is greater than maxRangeLen. The distance between start and end is
+// computed on unsigned integers so that it can't overflow.
+func rangeLen(start, end, step int64) (int, bool) {
+	var distance, stride uint64
+	switch {
+	case step > 0 && start < end:
+		distance, stride = uint64(end)-uint64(start), uint64(step)
+	case step < 0 && start > end:
+		distance, stride = uint64(start)-uint64(end), -uint64(step)
+	default:
+		return 0, true
+	}
+
+	count := (distance-1)/stride + 1
+	if count > maxRangeLen {
+		return 0, false
+	}
+
+	return int(count), true
+}
+
+// isTruthy mirrors the truthiness rules of the engines: null and false are
+// falsy, everything else is truthy.
+func isTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Null:
+		return false
+	case *Boolean:
+		return obj.Value
+	default:
+		return obj != nil
+	}
+}
+
+func isError(obj Object) bool {
+	return obj != nil && obj.Type() == ERROR_OBJ
+}
+
+// write writes s to the output of the runtime on behalf of the builtin name.
+func write(rt Runtime, name, s string) Object {
+	if _, err := io.WriteString(rt.Stdout(), s); err != nil {
+		return newError("`%s` failed to write output: %s", name, err)
+	}
+
+	return nil
+}
+
+// formatArgs implements format for the builtin name. The values are charged
+// by their length, as widths make short arguments long.
+func formatArgs(rt Runtime, name string, format Object, args []Object) (string, *Error) {
+	str, ok := format.(*String)
+	if !ok {
+		return "", newError("format of `%s` must be STRING, got %s", name, format.Type())
+	}
+
+	var out strings.Builder
+	next := 0
+
+	runes := []rune(str.Value)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '%' {
+			out.WriteRune(runes[i])
+			continue
+		}
+
+		start := i
+		i++
+		for i < len(runes) && strings.ContainsRune("+-# 0123456789.", runes[i]) {
+			i++
+		}
+
+		if i == len(runes) {
+			return "", newError("`%s` format ends with an incomplete verb %s", name, string(runes[start:]))
+		}
+
+		spec, verb := string(runes[start:i+1]), runes[i]
+		if verb == '%' {
+			out.WriteRune('%')
+			continue
+		}
+
+		if next == len(args) {
+			return "", newError("missing argument for %s in `%s` format", spec, name)
+		}
+		arg := args[next]
+		next++
+
+		value, err := formatValue(spec, verb, arg)
+		if err != nil {
+			return "", err
+		}
+		if err := rt.Charge(len(value)); err != nil {
+			return "", err
+		}
+		out.WriteString(value)
+	}
+
+	if next < len(args) {
+		return "", newError("`%s` got %d arguments for %d verbs", name, len(args), next)
+	}
+
+	return out.String(), nil
+}
+
+// formatValue formats arg according to the verb spec, e.g. "%5.2f".
+func formatValue(spec string, verb rune, arg Object) (string, *Error) {
+	switch verb {
+	case 's', 'v':
+		return fmt.Sprintf(spec[:len(spec)-1]+"s", arg.Inspect()), nil
+	case 'q':
+		if str, ok := arg.(*String); ok {
+			return fmt.Sprintf(spec[:len(spec)-1]+"s", strconv.Quote(str.Value)), nil
+		}
+	case 'd':
+		if integer, ok := arg.(*Integer); ok {
+			return fmt.Sprintf(spec, integer.Value), nil
+		}
+	case 'f':
+		if isNumber(arg) {
+			return fmt.Sprintf(spec, toFloat(arg)), nil
+		}
+	case 't':
+		if boolean, ok := arg.(*Boolean); ok {
+			return fmt.Sprintf(spec, boolean.Value), nil
+		}
+	default:
+		return "", newError("unknown verb %s", spec)
+	}
+
+	return "", newError("wrong argument for %s, got %s", spec, arg.Type())
+}
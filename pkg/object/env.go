@@ -1,13 +1,24 @@
 package object
 
+import (
+	"io"
+	"os"
+)
+
 type Env struct {
-	store map[string]Object
-	outer *Env
+	store  map[string]Object
+	outer  *Env
+	loop   string // the variable bound by a loop environment
+	stdout io.Writer
+	stderr io.Writer
+	meter  *Meter
 }
 
+// NewEnv returns a global environment, whose programs run with the default
+// limits until SetLimits is called.
 func NewEnv() *Env {
 	s := make(map[string]Object)
-	return &Env{store: s, outer: nil}
+	return &Env{store: s, outer: nil, meter: NewMeter(Limits{})}
 }
 
 func (e *Env) Get(name string) (Object, bool) {
@@ -20,13 +31,96 @@ func (e *Env) Get(name string) (Object, bool) {
 }
 
 func (e *Env) Set(name string, val Object) Object {
+	if e.loop != "" && name != e.loop {
+		return e.outer.Set(name, val)
+	}
+
 	e.store[name] = val
 	return val
 }
 
+// Assign replaces the value of an existing binding, looking it up in the
+// enclosing environments like Get does. It reports false, without binding
+// anything, when the name isn't bound.
+func (e *Env) Assign(name string, val Object) (Object, bool) {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = val
+		return val, true
+	}
+
+	if e.outer != nil {
+		return e.outer.Assign(name, val)
+	}
+
+	return nil, false
+}
+
+// SetStdout sets where builtins called within the environment, and the
+// environments enclosed by it, write their output.
+func (e *Env) SetStdout(w io.Writer) {
+	e.stdout = w
+}
+
+// Stdout returns the writer set with SetStdout on the environment or the
+// closest enclosing one, or os.Stdout when none was set.
+func (e *Env) Stdout() io.Writer {
+	if e.stdout != nil {
+		return e.stdout
+	}
+
+	if e.outer != nil {
+		return e.outer.Stdout()
+	}
+
+	return os.Stdout
+}
+
+// SetStderr sets the writer builtins called within the environment, and the
+// environments enclosed by it, report diagnostics to.
+func (e *Env) SetStderr(w io.Writer) {
+	e.stderr = w
+}
+
+// Stderr returns the writer set with SetStderr on the environment or the
+// closest enclosing one, or os.Stderr when none was set.
+func (e *Env) Stderr() io.Writer {
+	if e.stderr != nil {
+		return e.stderr
+	}
+
+	if e.outer != nil {
+		return e.outer.Stderr()
+	}
+
+	return os.Stderr
+}
+
+// SetLimits sets the limits of the programs run within the environment and
+// the environments enclosed by it.
+func (e *Env) SetLimits(limits Limits) {
+	e.meter = NewMeter(limits)
+}
+
+// Meter returns the meter of the environment or of the closest enclosing one
+// that has one.
+func (e *Env) Meter() *Meter {
+	if e.meter != nil || e.outer == nil {
+		return e.meter
+	}
+
+	return e.outer.Meter()
+}
+
 func NewEnclosedEnv(outer *Env) *Env {
-	env := NewEnv()
-	env.outer = outer
+	s := make(map[string]Object)
+	return &Env{store: s, outer: outer}
+}
 
+// NewLoopEnv returns an environment enclosed by outer that binds the variable
+// of a for loop while it runs. The other bindings made within the loop go to
+// outer, since blocks don't introduce scopes.
+func NewLoopEnv(outer *Env, variable string) *Env {
+	env := NewEnclosedEnv(outer)
+	env.loop = variable
 	return env
 }
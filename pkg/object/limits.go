@@ -0,0 +1,109 @@
+package object
+
+import (
+	"context"
+	"fmt"
+)
+
+// DefaultMaxDepth is the call depth allowed when Limits.MaxDepth is zero. It
+// keeps runaway recursion from exhausting the stack of the host.
+const DefaultMaxDepth = 10000
+
+// checkInterval is the number of steps between two checks of the context.
+const checkInterval = 1024
+
+// ErrorKind tells the errors raised by a program apart from the ones raised
+// when it runs out of its limits.
+type ErrorKind int
+
+const (
+	RuntimeError    ErrorKind = iota // raised by the program itself
+	StepLimitError                   // Limits.MaxSteps exceeded
+	DepthLimitError                  // Limits.MaxDepth exceeded
+	CanceledError                    // the context of the run is done
+)
+
+// Limits bound the resources a program may use.
+type Limits struct {
+	// MaxSteps is the number of evaluation steps, nodes for the evaluator
+	// and instructions for the VM, a run may take. Zero means no limit.
+	MaxSteps int
+	// MaxDepth is the number of nested function calls allowed. Zero means
+	// DefaultMaxDepth, a negative value no limit.
+	MaxDepth int
+}
+
+// Meter enforces Limits on the programs run by an engine.
+type Meter struct {
+	limits Limits
+	ctx    context.Context
+
+	steps     int
+	nextCheck int // the step at which the context is checked next
+	depth     int
+}
+
+func NewMeter(limits Limits) *Meter {
+	if limits.MaxDepth == 0 {
+		limits.MaxDepth = DefaultMaxDepth
+	}
+
+	return &Meter{limits: limits}
+}
+
+// Start begins a new run bound to ctx, which may be nil. The steps and calls
+// of previous runs no longer count.
+func (m *Meter) Start(ctx context.Context) {
+	m.ctx = ctx
+	m.steps = 0
+	m.nextCheck = 0
+	m.depth = 0
+}
+
+// Step accounts for one evaluation step. It returns an error once the run
+// exceeded its steps or its context is done.
+func (m *Meter) Step() *Error {
+	return m.Charge(1)
+}
+
+// Charge accounts for n evaluation steps at once, for the work a builtin does
+// like making the elements of an array. It returns an error like Step.
+func (m *Meter) Charge(n int) *Error {
+	m.steps += n
+
+	if m.limits.MaxSteps > 0 && m.steps > m.limits.MaxSteps {
+		return &Error{
+			Message: fmt.Sprintf("execution budget exceeded: more than %d steps", m.limits.MaxSteps),
+			Kind:    StepLimitError,
+		}
+	}
+
+	if m.ctx != nil && m.steps >= m.nextCheck {
+		m.nextCheck = m.steps + checkInterval
+		if err := m.ctx.Err(); err != nil {
+			return &Error{Message: "execution canceled: " + err.Error(), Kind: CanceledError}
+		}
+	}
+
+	return nil
+}
+
+// Enter accounts for a function call, which must be followed by Leave once
+// the call returns. It returns an error when the call would exceed the
+// maximum depth.
+func (m *Meter) Enter() *Error {
+	if m.limits.MaxDepth > 0 && m.depth >= m.limits.MaxDepth {
+		return &Error{
+			Message: fmt.Sprintf("maximum recursion depth exceeded: more than %d nested calls", m.limits.MaxDepth),
+			Kind:    DepthLimitError,
+		}
+	}
+
+	m.depth++
+	return nil
+}
+
+// Leave accounts for the return of a call entered with Enter.
+func (m *Meter) Leave() {
+	m.depth--
+}
@@ -2,22 +2,38 @@ package object
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
 	"monkey/pkg/ast"
+	"monkey/pkg/code"
+	"monkey/pkg/token"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 type ObjectType string
 
 const (
 	INTEGER_OBJ    = "INTEGER"
+	FLOAT_OBJ      = "FLOAT"
 	BOOLEAN_OBJ    = "BOOLEAN"
 	NULL_OBJ       = "NULL"
 	FUNC_OBJ       = "FUNC"
 	RETURN_VAL_OBJ = "RETURN_VALUE"
+	BREAK_OBJ      = "BREAK"
+	CONTINUE_OBJ   = "CONTINUE"
 	ERROR_OBJ      = "ERROR"
 	STRING_OBJ     = "STRING"
 	BUILTIN_OBJ    = "BUILTIN"
+	ARRAY_OBJ      = "ARRAY"
+	HASH_OBJ       = "HASH"
+
+	COMPILED_FUNC_OBJ = "COMPILED_FUNC"
 )
 
 type Object interface {
@@ -25,6 +41,69 @@ type Object interface {
 	Inspect() string
 }
 
+// HashKey identifies an object used as a key in a Hash. Two objects with the
+// same type and value always produce the same HashKey.
+type HashKey struct {
+	Type  ObjectType
+	Value uint64
+}
+
+// Hashable is implemented by every object that can be used as a hash key.
+type Hashable interface {
+	HashKey() HashKey
+}
+
+// Equal reports whether two objects hold the same value. Numbers, booleans
+// and strings are compared by value, arrays and hashes element by element and
+// everything else by identity.
+func Equal(left, right Object) bool {
+	if left.Type() != right.Type() {
+		return false
+	}
+
+	switch left := left.(type) {
+	case *Integer:
+		return left.Value == right.(*Integer).Value
+	case *Float:
+		return left.Value == right.(*Float).Value
+	case *Boolean:
+		return left.Value == right.(*Boolean).Value
+	case *Null:
+		return true
+	case *String:
+		return left.Value == right.(*String).Value
+	case *Array:
+		r := right.(*Array)
+		if len(left.Elements) != len(r.Elements) {
+			return false
+		}
+
+		for i := range left.Elements {
+			if !Equal(left.Elements[i], r.Elements[i]) {
+				return false
+			}
+		}
+
+		return true
+	case *Hash:
+		r := right.(*Hash)
+		if len(left.Pairs) != len(r.Pairs) {
+			return false
+		}
+
+		for key, pair := range left.Pairs {
+			other, ok := r.Pairs[key]
+			if !ok || !Equal(pair.Value, other.Value) {
+				return false
+			}
+		}
+
+		return true
+	default:
+		return left == right
+	}
+}
+
 type Integer struct {
 	Value int64
 }
@@ -37,6 +116,39 @@ func (i *Integer) Inspect() string {
 	return fmt.Sprintf("%d", i.Value)
 }
 
+func (i *Integer) HashKey() HashKey {
+	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
+}
+
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType {
+	return FLOAT_OBJ
+}
+
+// Inspect formats the float so that it reads back as a float: whole numbers
+// keep a ".0" suffix, and infinities and NaN, which have no literal, print as
+// the divisions making them: "1.0 / 0.0", "-1.0 / 0.0" and "0.0 / 0.0".
+func (f *Float) Inspect() string {
+	switch {
+	case math.IsInf(f.Value, 1):
+		return "1.0 / 0.0"
+	case math.IsInf(f.Value, -1):
+		return "-1.0 / 0.0"
+	case math.IsNaN(f.Value):
+		return "0.0 / 0.0"
+	}
+
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+
+	return s
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -49,6 +161,15 @@ func (b *Boolean) Inspect() string {
 	return fmt.Sprintf("%t", b.Value)
 }
 
+func (b *Boolean) HashKey() HashKey {
+	var value uint64
+	if b.Value {
+		value = 1
+	}
+
+	return HashKey{Type: b.Type(), Value: value}
+}
+
 type Null struct{}
 
 func (n *Null) Type() ObjectType {
@@ -63,6 +184,7 @@ type Func struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Env
+	Name       string // name of the binding the function was defined with, if any
 }
 
 func (f *Func) Type() ObjectType {
@@ -99,18 +221,175 @@ func (r *ReturnVal) Inspect() string {
 	return r.Value.Inspect()
 }
 
+// Break signals a 'break' statement unwinding to the enclosing loop.
+type Break struct{}
+
+func (b *Break) Type() ObjectType {
+	return BREAK_OBJ
+}
+
+func (b *Break) Inspect() string {
+	return "break"
+}
+
+// Continue signals a 'continue' statement unwinding to the enclosing loop.
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType {
+	return CONTINUE_OBJ
+}
+
+func (c *Continue) Inspect() string {
+	return "continue"
+}
+
 type Error struct {
 	Message string
+	Kind    ErrorKind
+	Pos     token.Position // where in the source the error was raised
+	// Stack holds the calls the error unwound through, innermost first.
+	Stack []StackFrame
+}
+
+// StackFrame describes a function call that was active when an error was
+// raised.
+type StackFrame struct {
+	Function string         // name of the called function
+	Pos      token.Position // position of the call expression
+	Args     string         // summary of the arguments
+}
+
+// maxArgLen is the length in runes after which arguments are truncated in
+// stack frames.
+const maxArgLen = 20
+
+// SummarizeArgs renders the arguments of a call for its StackFrame, functions
+// by their name and long values cut short.
+func SummarizeArgs(args []Object) string {
+	summary := make([]string, len(args))
+
+	for i, arg := range args {
+		var s string
+		switch arg := arg.(type) {
+		case *String:
+			s = fmt.Sprintf("%q", arg.Value)
+		case *Func:
+			s = functionSummary(arg.Name)
+		case *Closure:
+			s = functionSummary(arg.Fn.Name)
+		default:
+			s = arg.Inspect()
+		}
+
+		if runes := []rune(s); len(runes) > maxArgLen {
+			s = string(runes[:maxArgLen-3]) + "..."
+		}
+		summary[i] = s
+	}
+
+	return strings.Join(summary, ", ")
+}
+
+func functionSummary(name string) string {
+	if name == "" {
+		return "fn"
+	}
+
+	return "fn " + name
 }
 
 func (e *Error) Type() ObjectType {
 	return ERROR_OBJ
 }
 
+// Error implements the error interface so that the VM can raise an Error,
+// keeping its kind, as it is.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ErrorFrom returns err as an *Error, turning errors of other types into
+// runtime errors with the same message.
+func ErrorFrom(err error) *Error {
+	var errObj *Error
+	if errors.As(err, &errObj) {
+		return errObj
+	}
+
+	return &Error{Message: err.Error()}
+}
+
 func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "error: " + e.Pos.String() + ": " + e.Message
+	}
+
 	return "error: " + e.Message
 }
 
+// Traceback renders the call stack of the error, outermost call first, or
+// returns an empty string when the error was raised outside of any call.
+func (e *Error) Traceback() string {
+	if len(e.Stack) == 0 {
+		return ""
+	}
+
+	var lines []string
+	var previous string
+	repeated := 0
+
+	for i := len(e.Stack) - 1; i >= 0; i-- {
+		frame := e.Stack[i]
+
+		caller := "<main>"
+		if i+1 < len(e.Stack) {
+			caller = e.Stack[i+1].Function
+		}
+
+		// deep recursion repeats the same line over and over, only the first
+		// few repetitions are shown
+		line := fmt.Sprintf("  %s, in %s: %s(%s)\n", frame.Pos, caller, frame.Function, frame.Args)
+		if line == previous {
+			repeated++
+			if repeated >= maxRepeatedFrames {
+				continue
+			}
+		} else {
+			lines = appendRepeated(lines, repeated)
+			repeated = 0
+		}
+
+		lines = append(lines, line)
+		previous = line
+	}
+	lines = appendRepeated(lines, repeated)
+
+	// deep recursion with changing arguments doesn't repeat lines, only both
+	// ends of the stack are shown
+	if hidden := len(lines) - 2*maxTracebackEnd; hidden > 0 {
+		collapsed := append([]string{}, lines[:maxTracebackEnd]...)
+		collapsed = append(collapsed, fmt.Sprintf("  [%d more lines]\n", hidden))
+		lines = append(collapsed, lines[len(lines)-maxTracebackEnd:]...)
+	}
+
+	return "Traceback (most recent call last):\n" + strings.Join(lines, "")
+}
+
+// maxRepeatedFrames is the number of times a traceback shows the same frame
+// in a row.
+const maxRepeatedFrames = 3
+
+// maxTracebackEnd is the number of lines a long traceback shows at each end.
+const maxTracebackEnd = 10
+
+func appendRepeated(lines []string, repeated int) []string {
+	if hidden := repeated - maxRepeatedFrames + 1; hidden > 0 {
+		return append(lines, fmt.Sprintf("  [previous line repeated %d more times]\n", hidden))
+	}
+
+	return lines
+}
+
 type String struct {
 	Value string
 }
@@ -119,7 +398,80 @@ func (s *String) Type() ObjectType { return STRING_OBJ }
 
 func (s *String) Inspect() string { return s.Value }
 
-type BuiltInFunc func(args ...Object) Object
+// Len returns the number of characters (runes) in the string, which is what
+// Monkey programs index and count by.
+func (s *String) Len() int { return utf8.RuneCountInString(s.Value) }
+
+// ByteLen returns the length of the UTF-8 encoding of the string.
+func (s *String) ByteLen() int { return len(s.Value) }
+
+// CharAt returns the character at the given rune index as a string. It reports
+// false when the index is out of range.
+func (s *String) CharAt(i int64) (string, bool) {
+	if i < 0 {
+		return "", false
+	}
+
+	for _, r := range s.Value {
+		if i == 0 {
+			return string(r), true
+		}
+		i--
+	}
+
+	return "", false
+}
+
+func (s *String) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(s.Value))
+
+	return HashKey{Type: s.Type(), Value: h.Sum64()}
+}
+
+// Runtime is what the engine running a program provides to the builtins it
+// calls.
+type Runtime interface {
+	// Call calls fn, a function or a builtin, with args and returns its
+	// result, which is an *Error when the call fails. It lets builtins like
+	// map call back into Monkey code.
+	Call(fn Object, args []Object) Object
+	// Stdout is where builtins like puts write the program's output.
+	Stdout() io.Writer
+	// Stderr is where builtins report diagnostics.
+	Stderr() io.Writer
+	// Charge accounts for the work of a builtin as n steps of the run, so
+	// that Limits.MaxSteps bounds it. It returns an *Error the builtin must
+	// return once the run is out of steps or canceled.
+	Charge(n int) *Error
+}
+
+// BuiltInFunc implements a builtin. A nil result stands for null.
+type BuiltInFunc func(rt Runtime, args ...Object) Object
+
+// Iterate returns the elements a for loop visits when iterating over obj: the
+// elements of an array, the characters of a string or the keys of a hash in
+// the order of SortedPairs. It reports false when obj can't be iterated over.
+func Iterate(obj Object) ([]Object, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		return obj.Elements, true
+	case *String:
+		chars := []Object{}
+		for _, r := range obj.Value {
+			chars = append(chars, &String{Value: string(r)})
+		}
+		return chars, true
+	case *Hash:
+		keys := []Object{}
+		for _, pair := range obj.SortedPairs() {
+			keys = append(keys, pair.Key)
+		}
+		return keys, true
+	default:
+		return nil, false
+	}
+}
 
 type Builtin struct {
 	Fn BuiltInFunc
@@ -132,3 +484,104 @@ func (b *Builtin) Type() ObjectType {
 func (b *Builtin) Inspect() string {
 	return "builtin function"
 }
+
+type Array struct {
+	Elements []Object
+}
+
+func (a *Array) Type() ObjectType {
+	return ARRAY_OBJ
+}
+
+func (a *Array) Inspect() string {
+	var out bytes.Buffer
+
+	elements := []string{}
+	for _, e := range a.Elements {
+		elements = append(elements, e.Inspect())
+	}
+
+	out.WriteString("[")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("]")
+
+	return out.String()
+}
+
+// HashPair keeps the original key next to its value so a Hash can be
+// inspected and iterated.
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
+type Hash struct {
+	Pairs map[HashKey]HashPair
+}
+
+func (h *Hash) Type() ObjectType {
+	return HASH_OBJ
+}
+
+// SortedPairs returns the pairs of the hash ordered by the inspected form of
+// their keys, so output does not depend on map iteration order.
+func (h *Hash) SortedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key.Inspect() < pairs[j].Key.Inspect()
+	})
+
+	return pairs
+}
+
+func (h *Hash) Inspect() string {
+	var out bytes.Buffer
+
+	pairs := []string{}
+	for _, pair := range h.SortedPairs() {
+		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+	}
+
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// CompiledFunction holds the bytecode of a function produced by the compiler.
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	SourceMap     code.SourceMap // positions of the instructions, for errors
+	NumLocals     int
+	NumParameters int
+	Name          string // name of the binding the function was defined with, if any
+}
+
+func (cf *CompiledFunction) Type() ObjectType {
+	return COMPILED_FUNC_OBJ
+}
+
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// Closure is a compiled function together with the free variables it
+// captured when it was created. It is the VM's counterpart of Func and
+// reports the same type.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType {
+	return FUNC_OBJ
+}
+
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
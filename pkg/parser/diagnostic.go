@@ -0,0 +1,52 @@
+package parser
+
+import (
+	"fmt"
+	"monkey/pkg/token"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// Diagnostic is a problem found while parsing, together with the span of
+// source it refers to and an optional hint on how to fix it.
+type Diagnostic struct {
+	Severity Severity
+	Pos      token.Position // start of the offending source
+	End      token.Position // position immediately after the offending source
+	Message  string
+	Hint     string
+}
+
+// String renders the diagnostic in the "file:line:col: severity: message" form.
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Pos, d.Severity, d.Message)
+}
+
+// hints maps the tokens the parser may expect next to a suggestion that is
+// shown alongside the diagnostic.
+var hints = map[token.TokenType]string{
+	token.ASSIGN:   `bindings need a value, e.g. "var x = 1;"`,
+	token.RPAREN:   `insert a closing ")"`,
+	token.RBRACE:   `insert a closing "}"`,
+	token.RBRACKET: `insert a closing "]"`,
+	token.LPAREN:   `insert an opening "("`,
+	token.LBRACE:   `insert an opening "{"`,
+	token.COLON:    `separate hash keys and values with ":"`,
+	token.COMMA:    `separate elements with ","`,
+}
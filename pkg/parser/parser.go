@@ -6,6 +6,7 @@ import (
 	"monkey/pkg/lexer"
 	"monkey/pkg/token"
 	"strconv"
+	"strings"
 )
 
 type (
@@ -19,36 +20,66 @@ type Parser struct {
 	peekToken      token.Token
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
-	errors         []string
+	errors         []Diagnostic
+
+	// panicking is set after an error is reported and cleared once the parser
+	// has skipped to the next statement boundary. Errors reported in between
+	// are dropped, as they are usually caused by the first one. Statements
+	// don't consume their trailing ';' while panicking, so the error token is
+	// never skipped before synchronize looks at it.
+	panicking bool
+
+	// loopDepth counts the loops enclosing the current statement within the
+	// current function, 'break' and 'continue' are only valid inside one.
+	loopDepth int
 }
 
 const (
 	_ int = iota
 	LOWEST
+	OR          // ||
+	AND         // &&
 	EQUALS      // ==
 	LESSGREATER // > or <
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
 	CALL        // myFunction(X)
+	INDEX       // array[index]
 )
 
 var precedences = map[token.TokenType]int{
+	token.OR:       OR,
+	token.AND:      AND,
 	token.EQ:       EQUALS,
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
 	token.GT:       LESSGREATER,
+	token.LT_EQ:    LESSGREATER,
+	token.GT_EQ:    LESSGREATER,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.SLASH:    PRODUCT,
 	token.ASTERISK: PRODUCT,
 	token.LPAREN:   CALL,
+	token.LBRACKET: INDEX,
+}
+
+// Precedence returns the binding power of the infix operator t, or LOWEST when
+// t is not an infix operator. Calls and index expressions bind tighter than any
+// prefix operator.
+func Precedence(t token.TokenType) int {
+	if precedence, ok := precedences[t]; ok {
+		return precedence
+	}
+
+	return LOWEST
 }
 
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:              l,
-		errors:         []string{},
+		errors:         []Diagnostic{},
 		prefixParseFns: make(map[token.TokenType]prefixParseFn),
 		infixParseFns:  make(map[token.TokenType]infixParseFn),
 	}
@@ -61,6 +92,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
@@ -68,6 +100,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.FUNC, p.parseFuncLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
+	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
@@ -77,25 +110,111 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
+	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 
 	return p
 }
 
+// report records an error diagnostic spanning the given token unless the
+// parser is still recovering from a previous one.
+func (p *Parser) report(tok token.Token, hint string, format string, a ...interface{}) {
+	if p.panicking {
+		return
+	}
+
+	p.panicking = true
+	p.errors = append(p.errors, Diagnostic{
+		Severity: SeverityError,
+		Pos:      tok.Pos,
+		End:      tok.End,
+		Message:  fmt.Sprintf(format, a...),
+		Hint:     hint,
+	})
+}
+
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got=%s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.report(p.peekToken, hints[t], "expected next token to be %s, got=%s instead", t, p.peekToken.Type)
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	if t == token.ILLEGAL {
+		p.illegalTokenError()
+		return
+	}
+
+	p.report(p.curToken, "", "no prefix parse function for %s found", t)
+}
+
+// illegalTokenError explains the illegal tokens the lexer produces for
+// malformed literals and comments.
+func (p *Parser) illegalTokenError() {
+	lit := p.curToken.Literal
+
+	switch {
+	case strings.HasPrefix(lit, "/*"):
+		p.report(p.curToken, `close the comment with "*/"`, "unterminated block comment")
+	case strings.HasPrefix(lit, `"`):
+		p.report(p.curToken, `close the string with '"'`, "unterminated string literal")
+	case strings.HasPrefix(lit, "`"):
+		p.report(p.curToken, "close the string with '`'", "unterminated raw string literal")
+	case lit != "" && '0' <= lit[0] && lit[0] <= '9':
+		p.report(p.curToken, "write the exponent with digits, as in 1.5e3",
+			"malformed number %s", lit)
+	case strings.HasPrefix(lit, `\`):
+		p.report(p.curToken, `valid escapes are \n, \t, \r, \\, \" and \u{XXXX}`,
+			"invalid escape sequence %s", lit)
+	default:
+		p.report(p.curToken, "", "illegal character %q", lit)
+	}
 }
 
-func (p *Parser) Errors() []string {
+// Errors returns the diagnostics collected while parsing.
+func (p *Parser) Errors() []Diagnostic {
 	return p.errors
 }
 
+// synchronize skips tokens until the end of the statement that caused the
+// last error, leaving the parser on its final token. Braces opened while
+// skipping are matched so nested blocks are skipped as a whole. It reports
+// whether it stopped on a '}' closing an enclosing block instead, in which
+// case that token still has to be consumed by the caller.
+func (p *Parser) synchronize() bool {
+	defer func() { p.panicking = false }()
+
+	depth := 0
+	for !p.curTokenIs(token.EOF) {
+		switch p.curToken.Type {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth == 0 {
+				return true
+			}
+			depth--
+		}
+
+		if depth == 0 {
+			if p.curTokenIs(token.SEMICOLON) ||
+				p.peekTokenIs(token.RBRACE) ||
+				p.peekTokenIs(token.VAR) ||
+				p.peekTokenIs(token.RETURN) ||
+				p.peekTokenIs(token.WHILE) ||
+				p.peekTokenIs(token.FOR) {
+				return false
+			}
+		}
+
+		p.nextToken()
+	}
+
+	return false
+}
+
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
@@ -115,7 +234,13 @@ func (p *Parser) ParseProgram() *ast.Program {
 	for !p.curTokenIs(token.EOF) {
 		stmt := p.parseStatement()
 
-		if stmt != nil {
+		if p.panicking {
+			// a '}' without a matching '{' is skipped by nextToken below,
+			// along with the ';' ending it
+			if p.synchronize() && p.peekTokenIs(token.SEMICOLON) {
+				p.nextToken()
+			}
+		} else if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
 
@@ -134,21 +259,11 @@ func (p *Parser) peekTokenIs(t token.TokenType) bool {
 }
 
 func (p *Parser) peekPrecedence() int {
-	precedence, ok := precedences[p.peekToken.Type]
-	if !ok {
-		return LOWEST
-	}
-
-	return precedence
+	return Precedence(p.peekToken.Type)
 }
 
 func (p *Parser) curPrecedence() int {
-	precedence, ok := precedences[p.curToken.Type]
-	if !ok {
-		return LOWEST
-	}
-
-	return precedence
+	return Precedence(p.curToken.Type)
 }
 
 func (p *Parser) expectPeek(t token.TokenType) bool {
@@ -175,6 +290,12 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseVarStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK, token.CONTINUE:
+		return p.parseBranchStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -196,7 +317,11 @@ func (p *Parser) parseVarStatement() ast.Statement {
 	p.nextToken()
 	stmt.Value = p.parseExpression(LOWEST)
 
-	if p.peekTokenIs(token.SEMICOLON) {
+	if fn, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fn.Name = stmt.Name.Value
+	}
+
+	if !p.panicking && p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -209,7 +334,93 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 
 	stmt.ReturnValue = p.parseExpression(LOWEST)
 
-	if p.peekTokenIs(token.SEMICOLON) {
+	if !p.panicking && p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if !p.panicking && p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+
+	stmt.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if !p.panicking && p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+
+	return p.parseBlockStatement()
+}
+
+func (p *Parser) parseBranchStatement() ast.Statement {
+	stmt := &ast.BranchStatement{Token: p.curToken}
+
+	if p.loopDepth == 0 {
+		p.report(p.curToken, "", "%s outside of a loop", p.curToken.Literal)
+		return nil
+	}
+
+	if !p.panicking && p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -238,11 +449,58 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	return leftExp
 }
 
-func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
+func (p *Parser) parseExpressionStatement() ast.Statement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 	stmt.Expression = p.parseExpression(LOWEST)
 
-	if p.peekTokenIs(token.SEMICOLON) {
+	if !p.panicking && assignOperators[p.peekToken.Type] {
+		return p.parseAssignStatement(stmt.Expression)
+	}
+
+	if !p.panicking && p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+var assignOperators = map[token.TokenType]bool{
+	token.ASSIGN:          true,
+	token.PLUS_ASSIGN:     true,
+	token.MINUS_ASSIGN:    true,
+	token.ASTERISK_ASSIGN: true,
+	token.SLASH_ASSIGN:    true,
+}
+
+// parseAssignStatement parses the rest of an assignment whose target has
+// already been parsed, the assignment operator being the peek token.
+func (p *Parser) parseAssignStatement(target ast.Expression) ast.Statement {
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		tok := token.Token{Pos: target.Pos(), End: target.End()}
+		p.report(tok, "only variables and elements of arrays and hashes can be assigned to",
+			"cannot assign to %s", target.String())
+		return nil
+	}
+
+	p.nextToken()
+	stmt := &ast.AssignStatement{
+		Token:    p.curToken,
+		Target:   target,
+		Operator: p.curToken.Literal,
+	}
+
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if fn, ok := stmt.Value.(*ast.FunctionLiteral); ok && stmt.Operator == "=" {
+		if ident, ok := target.(*ast.Identifier); ok {
+			fn.Name = ident.Value
+		}
+	}
+
+	if !p.panicking && p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -253,10 +511,19 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	literal := &ast.IntegerLiteral{Token: p.curToken}
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		p.errors = append(
-			p.errors,
-			fmt.Sprintf("could not parse %q as integer", p.curToken.Literal),
-		)
+		p.report(p.curToken, "integers must fit in 64 bits", "could not parse %q as integer", p.curToken.Literal)
+		return nil
+	}
+
+	literal.Value = value
+	return literal
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	literal := &ast.FloatLiteral{Token: p.curToken}
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.report(p.curToken, "", "could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
 
@@ -315,13 +582,24 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	p.nextToken()
 	for !p.curTokenIs(token.EOF) && !p.curTokenIs(token.RBRACE) {
 		stmt := p.parseStatement()
-		if stmt != nil {
+		if p.panicking {
+			if p.synchronize() {
+				break
+			}
+		} else if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
 
 		p.nextToken()
 	}
 
+	if p.curTokenIs(token.RBRACE) {
+		block.Rbrace = p.curToken.End
+	} else {
+		// the input ended first, point at the brace left open
+		p.report(block.Token, "", "expected } to close the block, got EOF")
+	}
+
 	return block
 }
 
@@ -397,13 +675,19 @@ func (p *Parser) parseFuncLiteral() ast.Expression {
 		return nil
 	}
 
+	// loops around the function literal can't be left from its body
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
 	lit.Body = p.parseBlockStatement()
+	p.loopDepth = loopDepth
+
 	return lit
 }
 
 func (p *Parser) parseCallExpression(f ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Func: f}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	exp.Rparen = p.curToken.End
 
 	return exp
 }
@@ -418,9 +702,90 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayListeral{Token: p.curToken}
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	array.Rbracket = p.curToken.End
 	return array
 }
 
+func (p *Parser) parseHashLiteral() ast.Expression {
+	hash := &ast.HashLiteral{Token: p.curToken}
+	hash.Pairs = []ast.HashPair{}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		key := p.parseExpression(LOWEST)
+
+		if p.expectPeek(token.COLON) {
+			p.nextToken()
+			value := p.parseExpression(LOWEST)
+			if !p.panicking {
+				hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
+			}
+		}
+
+		if !p.panicking && !p.peekTokenIs(token.RBRACE) {
+			p.expectPeek(token.COMMA)
+		}
+
+		if p.panicking {
+			// drop the malformed pair, the ones after it still parse
+			if !p.skipHashPair() {
+				return nil
+			}
+			p.panicking = false
+			if p.peekTokenIs(token.COMMA) {
+				p.nextToken()
+			}
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	hash.Rbrace = p.curToken.End
+	return hash
+}
+
+// skipHashPair skips the rest of a malformed pair of a hash literal, leaving
+// the parser before the ',' or '}' ending it. Brackets opened while skipping
+// are matched. It reports false when the statement or the input ends first.
+func (p *Parser) skipHashPair() bool {
+	depth := 0
+	for !p.peekTokenIs(token.EOF) {
+		switch p.peekToken.Type {
+		case token.LPAREN, token.LBRACKET, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACKET, token.RBRACE:
+			if depth == 0 {
+				return p.peekTokenIs(token.RBRACE)
+			}
+			depth--
+		case token.COMMA, token.SEMICOLON:
+			if depth == 0 {
+				return p.peekTokenIs(token.COMMA)
+			}
+		}
+
+		p.nextToken()
+	}
+
+	return false
+}
+
+func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+
+	p.nextToken()
+	exp.Index = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	exp.Rbracket = p.curToken.End
+	return exp
+}
+
 func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	list := []ast.Expression{}
 
@@ -159,6 +159,29 @@ func TestStringLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	input := "2.5e-1;"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.FloatLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+	}
+
+	if literal.Value != 0.25 {
+		t.Errorf("literal.Value not %f. got=%f", 0.25, literal.Value)
+	}
+
+	if literal.TokenLiteral() != "2.5e-1" {
+		t.Errorf("literal.TokenLiteral not %q. got=%q", "2.5e-1", literal.TokenLiteral())
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input    string
@@ -265,6 +288,18 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"-a * b",
 			"((-a) * b)",
 		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a || b && c == d",
+			"(a || (b && (c == d)))",
+		},
+		{
+			"a && b || !c && d",
+			"((a && b) || ((!c) && d))",
+		},
 		{
 			"!-a",
 			"(!(-a))",
@@ -361,6 +396,14 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a + b + c * d / f + g)",
 			"add((((a + b) + ((c * d) / f)) + g))",
 		},
+		{
+			"a * [1, 2, 3, 4][b * c] * d",
+			"((a * ([1, 2, 3, 4][(b * c)])) * d)",
+		},
+		{
+			"add(a * b[2], b[1], 2 * [1, 2][1])",
+			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
+		},
 	}
 
 	for _, tt := range tests {
@@ -521,6 +564,136 @@ func TestIfElseExpression(t *testing.T) {
 	}
 }
 
+func TestAssignStatements(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedOperator string
+		expectedString   string
+	}{
+		{"x = 5;", "=", "x = 5;"},
+		{"x += y * 2", "+=", "x += (y * 2);"},
+		{"a[i + 1] -= 1;", "-=", "(a[(i + 1)]) -= 1;"},
+		{`h["k"] *= 2`, "*=", "(h[k]) *= 2;"},
+		{"f /= fn(x) { x }", "/=", "f /= fn(x)x;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d", len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.AssignStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.AssignStatement. got=%T", program.Statements[0])
+		}
+
+		if stmt.Operator != tt.expectedOperator {
+			t.Errorf("stmt.Operator is not %q. got=%q", tt.expectedOperator, stmt.Operator)
+		}
+
+		if stmt.String() != tt.expectedString {
+			t.Errorf("stmt.String() wrong. expected=%q, got=%q", tt.expectedString, stmt.String())
+		}
+	}
+}
+
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < y) { x; break; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.WhileStatement. got=%T",
+			program.Statements[0])
+	}
+
+	if !testInfixExpression(t, stmt.Condition, "x", "<", "y") {
+		return
+	}
+
+	if len(stmt.Body.Statements) != 2 {
+		t.Fatalf("body is not 2 statements. got=%d\n", len(stmt.Body.Statements))
+	}
+
+	branch, ok := stmt.Body.Statements[1].(*ast.BranchStatement)
+	if !ok || branch.TokenLiteral() != "break" {
+		t.Fatalf("Statements[1] is not a break statement. got=%T (%+v)",
+			stmt.Body.Statements[1], stmt.Body.Statements[1])
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	input := `for (x in [1, 2]) { if (x) { continue } }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ForStatement. got=%T",
+			program.Statements[0])
+	}
+
+	if !testIdentifier(t, stmt.Variable, "x") {
+		return
+	}
+
+	if _, ok := stmt.Iterable.(*ast.ArrayListeral); !ok {
+		t.Fatalf("stmt.Iterable is not ast.ArrayListeral. got=%T", stmt.Iterable)
+	}
+
+	if len(stmt.Body.Statements) != 1 {
+		t.Fatalf("body is not 1 statements. got=%d\n", len(stmt.Body.Statements))
+	}
+}
+
+func TestLoopStatementsWithSemicolons(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (x) { x }; y", "whilex xy"},
+		{"for (x in xs) { x }; y", "for(x in xs) xy"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 2 {
+			t.Fatalf("program.Statements does not contain 2 statements. got=%d",
+				len(program.Statements))
+		}
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program. expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := `fn(x, y) { x + y; }`
 
@@ -712,6 +885,268 @@ func TestParsingArrayListeral(t *testing.T) {
 	testInfixExpression(t, array.Elements[2], 3, "+", 3)
 }
 
+func TestParsingIndexExpressions(t *testing.T) {
+	input := "myArray[1 + 1]"
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, _ := program.Statements[0].(*ast.ExpressionStatement)
+	indexExp, ok := stmt.Expression.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.IndexExpression. got=%T", stmt.Expression)
+	}
+
+	if !testIdentifier(t, indexExp.Left, "myArray") {
+		return
+	}
+
+	if !testInfixExpression(t, indexExp.Index, 1, "+", 1) {
+		return
+	}
+}
+
+func TestParsingHashLiterals(t *testing.T) {
+	input := `{"one": 1, "two": 2, "three": 3}`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("exp is not ast.HashLiteral. got=%T", stmt.Expression)
+	}
+
+	expected := []struct {
+		key   string
+		value int64
+	}{
+		{"one", 1},
+		{"two", 2},
+		{"three", 3},
+	}
+
+	if len(hash.Pairs) != len(expected) {
+		t.Fatalf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
+	}
+
+	for i, pair := range hash.Pairs {
+		literal, ok := pair.Key.(*ast.StringLiteral)
+		if !ok {
+			t.Errorf("key is not ast.StringLiteral. got=%T", pair.Key)
+			continue
+		}
+
+		if literal.String() != expected[i].key {
+			t.Errorf("wrong key at %d. want=%q, got=%q", i, expected[i].key, literal.String())
+		}
+
+		testIntegerLiteral(t, pair.Value, expected[i].value)
+	}
+}
+
+func TestParsingEmptyHashLiteral(t *testing.T) {
+	input := "{}"
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("exp is not ast.HashLiteral. got=%T", stmt.Expression)
+	}
+
+	if len(hash.Pairs) != 0 {
+		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
+	}
+}
+
+func TestParsingHashLiteralsWithExpressions(t *testing.T) {
+	input := `{"one": 0 + 1, "two": 10 - 8, "three": 15 / 5}`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("exp is not ast.HashLiteral. got=%T", stmt.Expression)
+	}
+
+	if len(hash.Pairs) != 3 {
+		t.Fatalf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
+	}
+
+	testInfixExpression(t, hash.Pairs[0].Value, 0, "+", 1)
+	testInfixExpression(t, hash.Pairs[1].Value, 10, "-", 8)
+	testInfixExpression(t, hash.Pairs[2].Value, 15, "/", 5)
+}
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"var x 5;", "1:7: error: expected next token to be =, got=INT instead"},
+		{"var x = 1;\nadd(1, 2", "2:9: error: expected next token to be ), got=EOF instead"},
+		{"var y = );", "1:9: error: no prefix parse function for ) found"},
+		{"var z = 1;\n/* never closed", "2:1: error: unterminated block comment"},
+		{`var s = "open;`, "1:9: error: unterminated string literal"},
+		{"var f = 1.5e;", "1:9: error: malformed number 1.5e"},
+		{"break;", "1:1: error: break outside of a loop"},
+		{"var x = 1;\nx + 1 = 2", "2:1: error: cannot assign to (x + 1)"},
+		{"while (true) { fn() { continue } }", "1:23: error: continue outside of a loop"},
+		{"for (x of xs) {}", "1:8: error: expected next token to be IN, got=IDENT instead"},
+		{`var s = "a\qb";`, "1:11: error: invalid escape sequence \\q"},
+		{"if (true) { 1", "1:11: error: expected } to close the block, got EOF"},
+		{"var f = fn(x) {\n  while (x) { x", "2:13: error: expected } to close the block, got EOF"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q", tt.input)
+			continue
+		}
+
+		if errors[0].String() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errors[0])
+		}
+	}
+}
+
+func TestParserErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+		expectedHint  string
+		expectedProg  string
+	}{
+		{
+			"var x = (1 + 2; var y = 3;",
+			"1:15: error: expected next token to be ), got=; instead",
+			`insert a closing ")"`,
+			"var y = 3;",
+		},
+		{
+			"add(1, 2\nvar y = 3;",
+			"2:1: error: expected next token to be ), got=VAR instead",
+			`insert a closing ")"`,
+			"var y = 3;",
+		},
+		{
+			"if (x { 1 }; var y = 3;",
+			"1:7: error: expected next token to be ), got={ instead",
+			`insert a closing ")"`,
+			"var y = 3;",
+		},
+		{
+			"var f = fn() { var = 1; x }; f();",
+			"1:20: error: expected next token to be IDENT, got== instead",
+			"",
+			"var f = fn()x;f()",
+		},
+		{
+			"var f = fn() { var a = }; 5",
+			"1:24: error: no prefix parse function for } found",
+			"",
+			"var f = fn();5",
+		},
+		{
+			"1 + ) + 2; 3",
+			"1:5: error: no prefix parse function for ) found",
+			"",
+			"3",
+		},
+		{
+			"}; 5",
+			"1:1: error: no prefix parse function for } found",
+			"",
+			"5",
+		},
+		{
+			`{"a" 1}`,
+			"1:6: error: expected next token to be :, got=INT instead",
+			`separate hash keys and values with ":"`,
+			"{}",
+		},
+		{
+			`var h = {"a": 1 "b": 2, "c": 3}; h`,
+			"1:17: error: expected next token to be ,, got=STRING instead",
+			`separate elements with ","`,
+			"var h = {a: 1, c: 3};h",
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Errorf("expected 1 error for %q. got=%d (%v)", tt.input, len(errors), errors)
+			continue
+		}
+
+		if errors[0].String() != tt.expectedError {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expectedError, errors[0])
+		}
+
+		if errors[0].Hint != tt.expectedHint {
+			t.Errorf("wrong hint. expected=%q, got=%q", tt.expectedHint, errors[0].Hint)
+		}
+
+		if program.String() != tt.expectedProg {
+			t.Errorf("wrong program. expected=%q, got=%q", tt.expectedProg, program.String())
+		}
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	input := `var add = fn(a, b) {
+	a + b
+};
+add(1, [2][0]);`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	tests := []struct {
+		node  ast.Node
+		start string
+		end   string
+	}{
+		{program, "1:1", "4:15"},
+		{program.Statements[0], "1:1", "3:2"},
+		{program.Statements[0].(*ast.VarStatement).Value, "1:11", "3:2"},
+		{program.Statements[1], "4:1", "4:15"},
+		{program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression).Arguments[1], "4:8", "4:14"},
+	}
+
+	for i, tt := range tests {
+		if tt.node.Pos().String() != tt.start {
+			t.Errorf("tests[%d] - wrong start. expected=%q, got=%q", i, tt.start, tt.node.Pos())
+		}
+
+		if tt.node.End().String() != tt.end {
+			t.Errorf("tests[%d] - wrong end. expected=%q, got=%q", i, tt.end, tt.node.End())
+		}
+	}
+}
+
 func testVarStatement(t *testing.T, s ast.Statement, name string) bool {
 	if s.TokenLiteral() != "var" {
 		t.Errorf("s.TokenLiteral not 'var'. got=%q", s.TokenLiteral())
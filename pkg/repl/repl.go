@@ -4,28 +4,69 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"monkey/pkg/eval"
+	"monkey/pkg/ast"
+	"monkey/pkg/engine"
 	"monkey/pkg/lexer"
 	"monkey/pkg/object"
 	"monkey/pkg/parser"
+	"monkey/pkg/token"
+	"strings"
 )
 
 const PROMPT = ">> "
 
+// CONTINUATION_PROMPT is printed while reading the remaining lines of an
+// incomplete input.
+const CONTINUATION_PROMPT = ".. "
+
+// Config customizes a REPL session.
+type Config struct {
+	// Prompt is printed before reading each line. An empty prompt is useful
+	// when the input is piped in.
+	Prompt string
+	// ContinuationPrompt is printed before reading each further line of an
+	// input that isn't complete yet, like an unclosed function body.
+	ContinuationPrompt string
+	// Engine executes the input, the tree-walking evaluator is used when nil.
+	Engine engine.Engine
+}
+
 func Start(in io.Reader, out io.Writer) {
+	Run(in, out, Config{Prompt: PROMPT, ContinuationPrompt: CONTINUATION_PROMPT})
+}
+
+// Run starts a REPL session with the given configuration.
+func Run(in io.Reader, out io.Writer, cfg Config) {
 	scanner := bufio.NewScanner(in)
-	env := object.NewEnv()
+
+	e := cfg.Engine
+	if e == nil {
+		e = engine.NewEvaluator()
+	}
+	e.SetStdout(out)
+
+	var input strings.Builder
 
 	for {
-		fmt.Fprint(out, PROMPT)
-		scanned := scanner.Scan()
+		if input.Len() == 0 {
+			fmt.Fprint(out, cfg.Prompt)
+		} else {
+			fmt.Fprint(out, cfg.ContinuationPrompt)
+		}
 
+		scanned := scanner.Scan()
 		if !scanned {
 			return
 		}
 
-		line := scanner.Text()
-		l := lexer.New(line)
+		input.WriteString(scanner.Text())
+		input.WriteString("\n")
+		if !isComplete(input.String()) {
+			continue
+		}
+
+		l := lexer.New(input.String())
+		input.Reset()
 		p := parser.New(l)
 
 		program := p.ParseProgram()
@@ -34,7 +75,11 @@ func Start(in io.Reader, out io.Writer) {
 			continue
 		}
 
-		evaluated := eval.Eval(program, env)
+		evaluated := run(e, program)
+		if errObj, ok := evaluated.(*object.Error); ok {
+			io.WriteString(out, errObj.Traceback())
+		}
+
 		if evaluated != nil {
 			io.WriteString(out, evaluated.Inspect())
 			io.WriteString(out, "\n")
@@ -42,10 +87,50 @@ func Start(in io.Reader, out io.Writer) {
 	}
 }
 
-func printParseErrors(out io.Writer, errors []string) {
+// isComplete reports whether the input can be handed to the parser, that is
+// whether every brace, bracket and parenthesis opened has been closed and the
+// last string literal or block comment is terminated. Input that closes more
+// than it opens is considered complete so that the parser reports the error.
+func isComplete(input string) bool {
+	l := lexer.New(input)
+	depth := 0
+
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LBRACE, token.LBRACKET, token.LPAREN:
+			depth++
+		case token.RBRACE, token.RBRACKET, token.RPAREN:
+			depth--
+		case token.ILLEGAL:
+			if strings.HasPrefix(tok.Literal, `"`) || strings.HasPrefix(tok.Literal, "`") ||
+				strings.HasPrefix(tok.Literal, "/*") {
+				return false
+			}
+		}
+	}
+
+	return depth <= 0
+}
+
+// run executes the program, turning a Go panic raised while doing so into an
+// error so that a bug in the interpreter doesn't end the session.
+func run(e engine.Engine, program *ast.Program) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = &object.Error{Message: fmt.Sprintf("internal error: %v", r)}
+		}
+	}()
+
+	return e.Run(program)
+}
+
+func printParseErrors(out io.Writer, errors []parser.Diagnostic) {
 	io.WriteString(out, "Woops! We ran into some errors!\n")
 	io.WriteString(out, "parser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+	for _, d := range errors {
+		io.WriteString(out, "\t"+d.String()+"\n")
+		if d.Hint != "" {
+			io.WriteString(out, "\t\thint: "+d.Hint+"\n")
+		}
 	}
 }
@@ -0,0 +1,123 @@
+package repl
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"monkey/pkg/ast"
+	"monkey/pkg/object"
+	"strings"
+	"testing"
+)
+
+// panickingEngine simulates an interpreter bug on its first run.
+type panickingEngine struct {
+	runs int
+}
+
+func (e *panickingEngine) Define(name string, val object.Object) {}
+
+func (e *panickingEngine) SetStdout(w io.Writer) {}
+
+func (e *panickingEngine) SetStderr(w io.Writer) {}
+
+func (e *panickingEngine) Lookup(name string) (object.Object, bool) { return nil, false }
+
+func (e *panickingEngine) SetLimits(limits object.Limits) {}
+
+func (e *panickingEngine) Call(ctx context.Context, fn object.Object, args []object.Object) object.Object {
+	return nil
+}
+
+func (e *panickingEngine) RunContext(ctx context.Context, program *ast.Program) object.Object {
+	return e.Run(program)
+}
+
+func (e *panickingEngine) Run(program *ast.Program) object.Object {
+	e.runs++
+	if e.runs == 1 {
+		panic("boom")
+	}
+
+	return &object.Integer{Value: int64(e.runs)}
+}
+
+func TestRunRecoversFromPanics(t *testing.T) {
+	in := strings.NewReader("1\n2\n")
+	var out bytes.Buffer
+
+	Run(in, &out, Config{Engine: &panickingEngine{}})
+
+	expected := "error: internal error: boom\n2\n"
+	if out.String() != expected {
+		t.Errorf("wrong output. want=%q, got=%q", expected, out.String())
+	}
+}
+
+func TestRunKeepsGoingAfterRuntimeErrors(t *testing.T) {
+	in := strings.NewReader("var f = fn(a, b) { a / b };\nf(1)\nf(1, 0)\nf(4, 2)\n")
+	var out bytes.Buffer
+
+	Run(in, &out, Config{})
+
+	expected := "Traceback (most recent call last):\n" +
+		"  1:1, in <main>: f(1)\n" +
+		"error: 1:1: wrong number of arguments: want=2, got=1\n" +
+		"Traceback (most recent call last):\n" +
+		"  1:1, in <main>: f(1, 0)\n" +
+		"error: 1:20: division by zero\n" +
+		"2\n"
+	if out.String() != expected {
+		t.Errorf("wrong output. want=%q, got=%q", expected, out.String())
+	}
+}
+
+func TestRunMultiLineInput(t *testing.T) {
+	in := strings.NewReader("var add = fn(a, b) {\n  a + b\n};\nadd(1,\n2)\n\"a\nb\"\n")
+	var out bytes.Buffer
+
+	Run(in, &out, Config{Prompt: ">> ", ContinuationPrompt: ".. "})
+
+	expected := ">> .. .. >> .. 3\n>> .. a\nb\n>> "
+	if out.String() != expected {
+		t.Errorf("wrong output. want=%q, got=%q", expected, out.String())
+	}
+}
+
+func TestRunWritesProgramOutput(t *testing.T) {
+	in := strings.NewReader("puts(\"hi\")\nprint(1, 2)\n")
+	var out bytes.Buffer
+
+	Run(in, &out, Config{})
+
+	expected := "hi\nnull\n1 2null\n"
+	if out.String() != expected {
+		t.Errorf("wrong output. want=%q, got=%q", expected, out.String())
+	}
+}
+
+func TestIsComplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1 + 2", true},
+		{"fn(x) {", false},
+		{"fn(x) { x }", true},
+		{"[1, 2,", false},
+		{"add(1,", false},
+		{"{\"a\": [1, 2]}", true},
+		{"\"abc", false},
+		{"\"{\"", true},
+		{"/* fn(x) {", false},
+		{"1 // fn(x) {", true},
+		{"}", true},
+		{"", true},
+	}
+
+	for _, tt := range tests {
+		if got := isComplete(tt.input); got != tt.expected {
+			t.Errorf("isComplete(%q) wrong. want=%t, got=%t", tt.input, tt.expected, got)
+		}
+	}
+}
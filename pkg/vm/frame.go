@@ -0,0 +1,50 @@
+package vm
+
+import (
+	"monkey/pkg/code"
+	"monkey/pkg/object"
+	"monkey/pkg/token"
+)
+
+// Frame is the call frame of a closure being executed.
+type Frame struct {
+	cl          *object.Closure
+	ip          int   // instruction pointer within the closure's instructions
+	basePointer int   // stack pointer before the call, locals start here
+	loops       []int // stack pointer when each enclosing loop started
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
+
+// position returns the source position of the instruction being executed.
+func (f *Frame) position() token.Position {
+	return f.cl.Fn.SourceMap.Lookup(f.ip)
+}
+
+// iterator holds the state of a for loop, it's stored in a hidden binding of
+// the loop.
+type iterator struct {
+	elements []object.Object
+	next     int // index of the next element
+}
+
+func (it *iterator) Type() object.ObjectType { return "ITERATOR" }
+
+func (it *iterator) Inspect() string { return "iterator" }
+
+// cell holds a variable captured by a closure. Locals are moved into a cell
+// when they are first captured so that the closure and the function defining
+// them share it, free variables are always cells.
+type cell struct {
+	value object.Object
+}
+
+func (c *cell) Type() object.ObjectType { return "CELL" }
+
+func (c *cell) Inspect() string { return "cell" }
@@ -0,0 +1,1026 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"monkey/pkg/code"
+	"monkey/pkg/compiler"
+	"monkey/pkg/object"
+	"os"
+)
+
+const (
+	// StackSize is the initial size of the stack, which grows as needed.
+	// Deep recursion is bounded by Limits.MaxDepth like in the evaluator.
+	StackSize   = 2048
+	GlobalsSize = 65536
+)
+
+var (
+	Null  = &object.Null{}
+	True  = &object.Boolean{Value: true}
+	False = &object.Boolean{Value: false}
+)
+
+// infixOperators maps the opcodes of binary operations to the operator they
+// implement, used in error messages.
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpGreaterThan:  ">",
+	code.OpLessThan:     "<",
+	code.OpGreaterEqual: ">=",
+	code.OpLessEqual:    "<=",
+}
+
+type VM struct {
+	constants []object.Object
+
+	stack []object.Object
+	sp    int // always points to the next free slot, the top of the stack is stack[sp-1]
+
+	globals     []object.Object
+	globalNames []string // the names of the globals, for errors
+
+	frames      []*Frame
+	framesIndex int
+
+	stdout io.Writer // where builtins write their output
+	stderr io.Writer // where builtins report diagnostics
+
+	meter *object.Meter
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions, SourceMap: bytecode.SourceMap}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := []*Frame{mainFrame}
+
+	return &VM{
+		constants:   bytecode.Constants,
+		stack:       make([]object.Object, StackSize),
+		sp:          0,
+		globals:     make([]object.Object, GlobalsSize),
+		globalNames: bytecode.GlobalNames,
+		frames:      frames,
+		framesIndex: 1,
+		stdout:      os.Stdout,
+		stderr:      os.Stderr,
+		meter:       object.NewMeter(object.Limits{}),
+	}
+}
+
+// NewWithGlobalsStore returns a VM that reads and writes globals in s, so
+// that they survive between runs of the REPL.
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = s
+	return vm
+}
+
+// SetStdout sets where builtins like puts write their output, os.Stdout by
+// default.
+func (vm *VM) SetStdout(w io.Writer) {
+	vm.stdout = w
+}
+
+// Stdout returns the writer set with SetStdout.
+func (vm *VM) Stdout() io.Writer {
+	return vm.stdout
+}
+
+// SetStderr sets where builtins report diagnostics, os.Stderr by default.
+func (vm *VM) SetStderr(w io.Writer) {
+	vm.stderr = w
+}
+
+// Stderr returns the writer set with SetStderr.
+func (vm *VM) Stderr() io.Writer {
+	return vm.stderr
+}
+
+// Charge implements object.Runtime, builtins account for their work in the
+// steps of the VM.
+func (vm *VM) Charge(n int) *object.Error {
+	return vm.meter.Charge(n)
+}
+
+// SetLimits sets the limits of the programs the VM runs. The VM counts
+// instructions as steps.
+func (vm *VM) SetLimits(limits object.Limits) {
+	vm.meter = object.NewMeter(limits)
+}
+
+// LastPoppedStackElem returns the value of the last expression statement the
+// VM executed, or the value returned by a top-level return statement.
+func (vm *VM) LastPoppedStackElem() object.Object {
+	if vm.sp >= len(vm.stack) {
+		return nil
+	}
+
+	return vm.stack[vm.sp]
+}
+
+// Run executes the bytecode until the main program finishes or a runtime
+// error occurs.
+func (vm *VM) Run() error {
+	return vm.RunContext(context.Background())
+}
+
+// RunContext is like Run but stops with an error once ctx is done. Errors
+// raised by the limits of the VM are *object.Error values.
+func (vm *VM) RunContext(ctx context.Context) error {
+	vm.meter.Start(ctx)
+	return vm.run(0)
+}
+
+// run executes instructions until the main program finishes or, when depth
+// isn't 0, until a return brings the number of active frames down to depth.
+// Errors are *object.Error values located at the instruction that raised them.
+func (vm *VM) run(depth int) error {
+	if err := vm.execute(depth); err != nil {
+		return vm.traceback(object.ErrorFrom(err), depth)
+	}
+
+	return nil
+}
+
+func (vm *VM) execute(depth int) error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		if err := vm.meter.Step(); err != nil {
+			return err
+		}
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if err := vm.push(vm.constants[constIndex]); err != nil {
+				return err
+			}
+
+		case code.OpPop:
+			vm.pop()
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan,
+			code.OpGreaterEqual, code.OpLessEqual:
+			if err := vm.executeInfixOperation(op); err != nil {
+				return err
+			}
+
+		case code.OpTrue:
+			if err := vm.push(True); err != nil {
+				return err
+			}
+
+		case code.OpFalse:
+			if err := vm.push(False); err != nil {
+				return err
+			}
+
+		case code.OpNull:
+			if err := vm.push(Null); err != nil {
+				return err
+			}
+
+		case code.OpBang:
+			if err := vm.executeBangOperator(); err != nil {
+				return err
+			}
+
+		case code.OpMinus:
+			if err := vm.executeMinusOperator(); err != nil {
+				return err
+			}
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			condition := vm.pop()
+			if !isTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpIter:
+			iterable := vm.pop()
+
+			elements, ok := object.Iterate(iterable)
+			if !ok {
+				return fmt.Errorf("not iterable: %s", iterable.Type())
+			}
+
+			if err := vm.push(&iterator{elements: elements}); err != nil {
+				return err
+			}
+
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			it := vm.pop().(*iterator)
+			if it.next == len(it.elements) {
+				vm.currentFrame().ip = pos - 1
+				continue
+			}
+
+			it.next++
+			if err := vm.push(it.elements[it.next-1]); err != nil {
+				return err
+			}
+
+		case code.OpEnterLoop:
+			frame := vm.currentFrame()
+			frame.loops = append(frame.loops, vm.sp)
+
+		case code.OpLeaveLoop:
+			frame := vm.currentFrame()
+			frame.loops = frame.loops[:len(frame.loops)-1]
+
+		case code.OpUnwindLoop:
+			frame := vm.currentFrame()
+			vm.sp = frame.loops[len(frame.loops)-1]
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			vm.globals[globalIndex] = vm.pop()
+			// a binding has no value, don't let LastPoppedStackElem report it
+			vm.stack[vm.sp] = nil
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			// the compiler gives a slot to the names used before they
+			// are defined
+			global := vm.globals[globalIndex]
+			if global == nil {
+				return fmt.Errorf("identifier not found: %s", vm.globalName(int(globalIndex)))
+			}
+
+			if err := vm.push(global); err != nil {
+				return err
+			}
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			slot := &vm.stack[frame.basePointer+int(localIndex)]
+			if c, ok := (*slot).(*cell); ok {
+				c.value = vm.pop()
+			} else {
+				*slot = vm.pop()
+			}
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			local := vm.stack[frame.basePointer+int(localIndex)]
+			if c, ok := local.(*cell); ok {
+				local = c.value
+			}
+
+			if err := vm.push(local); err != nil {
+				return err
+			}
+
+		case code.OpCaptureLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			// from now on the local lives in a cell shared with the closure
+			frame := vm.currentFrame()
+			slot := &vm.stack[frame.basePointer+int(localIndex)]
+			c, ok := (*slot).(*cell)
+			if !ok {
+				c = &cell{value: *slot}
+				*slot = c
+			}
+
+			if err := vm.push(c); err != nil {
+				return err
+			}
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			definition := object.Builtins[builtinIndex]
+			if err := vm.push(definition.Builtin); err != nil {
+				return err
+			}
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			if err := vm.push(currentClosure.Free[freeIndex].(*cell).value); err != nil {
+				return err
+			}
+
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			currentClosure.Free[freeIndex].(*cell).value = vm.pop()
+
+		case code.OpCaptureFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			if err := vm.push(currentClosure.Free[freeIndex]); err != nil {
+				return err
+			}
+
+		case code.OpCurrentClosure:
+			currentClosure := vm.currentFrame().cl
+			if err := vm.push(currentClosure); err != nil {
+				return err
+			}
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+
+			if err := vm.push(array); err != nil {
+				return err
+			}
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp = vm.sp - numElements
+
+			if err := vm.push(hash); err != nil {
+				return err
+			}
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+
+			if err := vm.executeIndexExpression(left, index); err != nil {
+				return err
+			}
+
+		case code.OpSetIndex:
+			val := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			if err := vm.executeSetIndex(left, index, val); err != nil {
+				return err
+			}
+			// an assignment has no value, don't let LastPoppedStackElem report it
+			vm.stack[vm.sp] = nil
+
+		case code.OpUpdateIndex:
+			op := code.Opcode(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip += 1
+
+			val := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			if err := vm.checkSetIndex(left, index); err != nil {
+				return err
+			}
+
+			// combine the current element with the value on the stack
+			if err := vm.executeIndexExpression(left, index); err != nil {
+				return err
+			}
+			if err := vm.push(val); err != nil {
+				return err
+			}
+			if err := vm.executeInfixOperation(op); err != nil {
+				return err
+			}
+
+			if err := vm.executeSetIndex(left, index, vm.pop()); err != nil {
+				return err
+			}
+			vm.stack[vm.sp] = nil
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.executeCall(int(numArgs)); err != nil {
+				return err
+			}
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+
+			if vm.framesIndex == 1 {
+				// a return statement in the main program ends it, the value
+				// was just popped so LastPoppedStackElem still sees it
+				return nil
+			}
+
+			frame := vm.popFrame()
+			vm.meter.Leave()
+			vm.sp = frame.basePointer - 1
+
+			if err := vm.push(returnValue); err != nil {
+				return err
+			}
+
+			if vm.framesIndex == depth {
+				return nil
+			}
+
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.meter.Leave()
+			vm.sp = frame.basePointer - 1
+
+			if err := vm.push(Null); err != nil {
+				return err
+			}
+
+			if vm.framesIndex == depth {
+				return nil
+			}
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			if err := vm.pushClosure(int(constIndex), int(numFree)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// traceback locates err at the current instruction, unless it already knows
+// where it was raised, and adds the calls active above depth to its stack.
+// The frame at depth itself was called by Call rather than by the program.
+func (vm *VM) traceback(err *object.Error, depth int) *object.Error {
+	if !err.Pos.IsValid() {
+		err.Pos = vm.currentFrame().position()
+	}
+
+	for i := vm.framesIndex - 1; i > depth; i-- {
+		callee, caller := vm.frames[i], vm.frames[i-1]
+		err.Stack = append(err.Stack, object.StackFrame{
+			Function: functionName(callee.cl.Fn),
+			Pos:      caller.position(),
+			Args:     object.SummarizeArgs(vm.arguments(callee)),
+		})
+	}
+
+	return err
+}
+
+// arguments returns the current values of the parameters of frame.
+func (vm *VM) arguments(frame *Frame) []object.Object {
+	args := make([]object.Object, frame.cl.Fn.NumParameters)
+	for i := range args {
+		args[i] = vm.stack[frame.basePointer+i]
+		if c, ok := args[i].(*cell); ok {
+			args[i] = c.value
+		}
+	}
+
+	return args
+}
+
+func (vm *VM) globalName(index int) string {
+	if index < len(vm.globalNames) {
+		return vm.globalNames[index]
+	}
+
+	return fmt.Sprintf("global %d", index)
+}
+
+func functionName(fn *object.CompiledFunction) string {
+	if fn.Name != "" {
+		return fn.Name
+	}
+
+	return "<anonymous>"
+}
+
+func builtinName(builtin *object.Builtin) string {
+	for _, b := range object.Builtins {
+		if b.Builtin == builtin {
+			return b.Name
+		}
+	}
+
+	return "<builtin>"
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) {
+	if vm.framesIndex == len(vm.frames) {
+		vm.frames = append(vm.frames, f)
+	} else {
+		vm.frames[vm.framesIndex] = f
+	}
+	vm.framesIndex++
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+func (vm *VM) push(o object.Object) error {
+	vm.reserve(vm.sp + 1)
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+
+	return nil
+}
+
+// reserve grows the stack so that it holds at least size elements.
+func (vm *VM) reserve(size int) {
+	if size <= len(vm.stack) {
+		return
+	}
+
+	n := 2 * len(vm.stack)
+	for n < size {
+		n *= 2
+	}
+
+	stack := make([]object.Object, n)
+	copy(stack, vm.stack)
+	vm.stack = stack
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+// executeInfixOperation mirrors the semantics, including the error messages,
+// of the tree-walking evaluator.
+func (vm *VM) executeInfixOperation(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	leftType := left.Type()
+	rightType := right.Type()
+	operator := infixOperators[op]
+
+	switch {
+	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
+		return vm.executeIntegerOperation(op, left, right)
+	case isNumber(left) && isNumber(right):
+		return vm.executeFloatOperation(op, toFloat(left), toFloat(right))
+	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
+		return vm.executeStringOperation(op, left, right)
+	case leftType == object.ARRAY_OBJ && rightType == object.ARRAY_OBJ,
+		leftType == object.HASH_OBJ && rightType == object.HASH_OBJ:
+		switch op {
+		case code.OpEqual:
+			return vm.push(nativeBoolToBooleanObject(object.Equal(left, right)))
+		case code.OpNotEqual:
+			return vm.push(nativeBoolToBooleanObject(!object.Equal(left, right)))
+		}
+	case op == code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(object.Equal(left, right)))
+	case op == code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(!object.Equal(left, right)))
+	case leftType != rightType:
+		return fmt.Errorf("type mismatch: %s %s %s", leftType, operator, rightType)
+	}
+
+	return fmt.Errorf("unknown operator: %s %s %s", leftType, operator, rightType)
+}
+
+func (vm *VM) executeIntegerOperation(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.Integer).Value
+	rightValue := right.(*object.Integer).Value
+
+	switch op {
+	case code.OpAdd:
+		return vm.push(&object.Integer{Value: leftValue + rightValue})
+	case code.OpSub:
+		return vm.push(&object.Integer{Value: leftValue - rightValue})
+	case code.OpMul:
+		return vm.push(&object.Integer{Value: leftValue * rightValue})
+	case code.OpDiv:
+		if rightValue == 0 {
+			return errors.New("division by zero")
+		}
+		return vm.push(&object.Integer{Value: leftValue / rightValue})
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	case code.OpGreaterEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	case code.OpLessEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue <= rightValue))
+	default:
+		return fmt.Errorf("unknown operator: %s %s %s",
+			left.Type(), infixOperators[op], right.Type())
+	}
+}
+
+// executeFloatOperation runs an operation between two numbers where at least
+// one of them was a float, both operands already promoted.
+func (vm *VM) executeFloatOperation(op code.Opcode, left, right float64) error {
+	switch op {
+	case code.OpAdd:
+		return vm.push(&object.Float{Value: left + right})
+	case code.OpSub:
+		return vm.push(&object.Float{Value: left - right})
+	case code.OpMul:
+		return vm.push(&object.Float{Value: left * right})
+	case code.OpDiv:
+		return vm.push(&object.Float{Value: left / right})
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(left == right))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(left != right))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(left > right))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(left < right))
+	case code.OpGreaterEqual:
+		return vm.push(nativeBoolToBooleanObject(left >= right))
+	case code.OpLessEqual:
+		return vm.push(nativeBoolToBooleanObject(left <= right))
+	default:
+		return fmt.Errorf("unknown operator: FLOAT %s FLOAT", infixOperators[op])
+	}
+}
+
+func (vm *VM) executeStringOperation(op code.Opcode, left, right object.Object) error {
+	if op != code.OpAdd {
+		return fmt.Errorf("unknown operator: %s %s %s",
+			left.Type(), infixOperators[op], right.Type())
+	}
+
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	return vm.push(&object.String{Value: leftValue + rightValue})
+}
+
+func (vm *VM) executeBangOperator() error {
+	operand := vm.pop()
+
+	return vm.push(nativeBoolToBooleanObject(!isTruthy(operand)))
+}
+
+func (vm *VM) executeMinusOperator() error {
+	operand := vm.pop()
+
+	switch operand := operand.(type) {
+	case *object.Integer:
+		return vm.push(&object.Integer{Value: -operand.Value})
+	case *object.Float:
+		return vm.push(&object.Float{Value: -operand.Value})
+	default:
+		return fmt.Errorf("unknown operator: -%s", operand.Type())
+	}
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+
+	for i := startIndex; i < endIndex; i++ {
+		elements[i-startIndex] = vm.stack[i]
+	}
+
+	return &object.Array{Elements: elements}
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	hashedPairs := make(map[object.HashKey]object.HashPair)
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+
+		hashedPairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+
+	return &object.Hash{Pairs: hashedPairs}, nil
+}
+
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeStringIndex(left, index)
+	case left.Type() == object.HASH_OBJ:
+		return vm.executeHashIndex(left, index)
+	default:
+		return fmt.Errorf("index operator not supported: %s[%s]", left.Type(), index.Type())
+	}
+}
+
+func (vm *VM) executeArrayIndex(array, index object.Object) error {
+	elements := array.(*object.Array).Elements
+	i := index.(*object.Integer).Value
+	max := int64(len(elements) - 1)
+
+	if i < 0 || i > max {
+		return vm.push(Null)
+	}
+
+	return vm.push(elements[i])
+}
+
+func (vm *VM) executeStringIndex(str, index object.Object) error {
+	char, ok := str.(*object.String).CharAt(index.(*object.Integer).Value)
+	if !ok {
+		return vm.push(Null)
+	}
+
+	return vm.push(&object.String{Value: char})
+}
+
+// checkSetIndex returns an error when left[index] can't be assigned to.
+func (vm *VM) checkSetIndex(left, index object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return fmt.Errorf("index operator not supported: %s[%s]", left.Type(), index.Type())
+		}
+
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return fmt.Errorf("index out of range: %d", i.Value)
+		}
+	case *object.Hash:
+		if _, ok := index.(object.Hashable); !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+
+	return nil
+}
+
+func (vm *VM) executeSetIndex(left, index, val object.Object) error {
+	if err := vm.checkSetIndex(left, index); err != nil {
+		return err
+	}
+
+	switch left := left.(type) {
+	case *object.Array:
+		left.Elements[index.(*object.Integer).Value] = val
+	case *object.Hash:
+		key := index.(object.Hashable).HashKey()
+		left.Pairs[key] = object.HashPair{Key: index, Value: val}
+	}
+
+	return nil
+}
+
+func (vm *VM) executeHashIndex(hash, index object.Object) error {
+	hashObject := hash.(*object.Hash)
+
+	key, ok := index.(object.Hashable)
+	if !ok {
+		return fmt.Errorf("unusable as hash key: %s", index.Type())
+	}
+
+	pair, ok := hashObject.Pairs[key.HashKey()]
+	if !ok {
+		return vm.push(Null)
+	}
+
+	return vm.push(pair.Value)
+}
+
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return fmt.Errorf("not a function: %s", callee.Type())
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters, numArgs)
+	}
+
+	if err := vm.meter.Enter(); err != nil {
+		// like in the evaluator, the call that went too deep is recorded
+		pos := vm.currentFrame().position()
+		err.Pos = pos
+		err.Stack = append(err.Stack, object.StackFrame{
+			Function: functionName(cl.Fn),
+			Pos:      pos,
+			Args:     object.SummarizeArgs(vm.stack[vm.sp-numArgs : vm.sp]),
+		})
+		return err
+	}
+
+	basePointer := vm.sp - numArgs
+	vm.reserve(basePointer + cl.Fn.NumLocals + 1)
+
+	frame := NewFrame(cl, basePointer)
+	vm.pushFrame(frame)
+
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+
+	// clear the locals left over by previous calls, a leftover cell would
+	// otherwise be written through
+	for i := frame.basePointer + numArgs; i < vm.sp; i++ {
+		vm.stack[i] = nil
+	}
+
+	return nil
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	result := builtin.Fn(vm, args...)
+	vm.sp = vm.sp - numArgs - 1
+
+	if errObj, ok := result.(*object.Error); ok {
+		// builtins aren't frames of the VM, the call is recorded here
+		pos := vm.currentFrame().position()
+		if !errObj.Pos.IsValid() {
+			errObj.Pos = pos
+		}
+		errObj.Stack = append(errObj.Stack, object.StackFrame{
+			Function: builtinName(builtin),
+			Pos:      pos,
+			Args:     object.SummarizeArgs(args),
+		})
+		return errObj
+	}
+
+	if result == nil {
+		result = Null
+	}
+
+	return vm.push(result)
+}
+
+// CallContext calls fn like Call, starting a new run of the limits of the VM
+// that stops once ctx is done.
+func (vm *VM) CallContext(ctx context.Context, fn object.Object, args []object.Object) object.Object {
+	vm.meter.Start(ctx)
+	return vm.Call(fn, args)
+}
+
+// Call calls fn, a closure or a builtin, and runs it to completion. It returns
+// the result of the call or an *object.Error. Builtins use it to call back
+// into the program.
+func (vm *VM) Call(fn object.Object, args []object.Object) object.Object {
+	depth, sp := vm.framesIndex, vm.sp
+
+	err := vm.push(fn)
+	for _, arg := range args {
+		if err == nil {
+			err = vm.push(arg)
+		}
+	}
+
+	if err == nil {
+		err = vm.executeCall(len(args))
+	}
+
+	// builtins are done once executeCall returns, closures have to be run
+	if _, ok := fn.(*object.Closure); ok && err == nil {
+		err = vm.run(depth)
+	}
+
+	if err != nil {
+		// drop the frames of the failed call, the error already records them
+		for vm.framesIndex > depth {
+			vm.popFrame()
+			vm.meter.Leave()
+		}
+		vm.sp = sp
+
+		return object.ErrorFrom(err)
+	}
+
+	return vm.pop()
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+		if _, ok := free[i].(*cell); !ok {
+			free[i] = &cell{value: free[i]}
+		}
+	}
+	vm.sp = vm.sp - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return True
+	}
+	return False
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	default:
+		return true
+	}
+}
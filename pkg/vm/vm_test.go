@@ -0,0 +1,545 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"monkey/internal/evaltest"
+	"monkey/pkg/ast"
+	"monkey/pkg/compiler"
+	"monkey/pkg/eval"
+	"monkey/pkg/lexer"
+	"monkey/pkg/object"
+	"monkey/pkg/parser"
+	"strings"
+	"testing"
+)
+
+type vmTestCase struct {
+	input    string
+	expected interface{}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1", 1},
+		{"1 + 2", 3},
+		{"1 - 2", -1},
+		{"4 / 2", 2},
+		{"50 / 2 * 2 + 10 - 5", 55},
+		{"5 * (2 + 10)", 60},
+		{"-50 + 100 + -50", 0},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true", true},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 == 1", true},
+		{"1 != 2", true},
+		{"true != false", true},
+		{"(1 < 2) == true", true},
+		{"!5", false},
+		{"!!true", true},
+		{"!(if (false) { 5; })", true},
+		{"[1, [2]] == [1, [2]]", true},
+		{`{"a": 1} != {"a": 2}`, true},
+		{"1 == true", false},
+		{"2 <= 2", true},
+		{"1.5 >= 2", false},
+		{"true && 1", true},
+		{"1 > 2 && 1 / 0", false},
+		{"(if (false) { 1 }) || true", true},
+		{"false || (if (false) { 1 })", false},
+		{"1 < 2 || 1 / 0", true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (true) { 10 }", 10},
+		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (1 > 2) { 10 }", Null},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+		{"if (true) { var a = 5; }", Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"var i = 0; while (i < 5) { var i = i + 1; } i", 5},
+		{"var i = 0; while (true) { var i = i + 1; if (i == 3) { break; } } i", 3},
+		{"var n = 0; for (x in range(10)) { if (x > 3) { break } var n = n + x; } n", 6},
+		{`var s = ""; for (c in "abc") { var s = c + s; } s`, "cba"},
+		{"var f = fn() { var n = 0; for (x in [1, 2, 3]) { if (x == 2) { continue } var n = n + x; } n }; f()", 4},
+		{"var f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } }; f()", 20},
+		{"var f = fn() { while (false) {} }; f()", Null},
+		{"var n = 0; for (i in range(3)) { for (j in range(3)) { if (j == i) { break } var n = n + 1; } } n", 3},
+		{"var x = 10; for (x in [1, 2]) { var n = x; } x + n", 12},
+		{"var f = fn() { var x = 10; for (x in [1, 2]) { x += 1 } x }; f()", 10},
+		// break and continue in the middle of an expression don't leave its
+		// operands on the stack, which would overflow long before the end
+		{"var i = 0; while (i < 3000) { var i = i + 1; [i, if (true) { continue } else { 0 }]; } i", 3000},
+		{"var f = fn() { var n = 0; for (x in range(3000)) { var n = n + 1; [x, x + if (x < 2999) { continue } else { break }]; } n }; f()", 3000},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []vmTestCase{
+		{"var x = 1; x = 2; x", 2},
+		{"var x = 1; x += 2; x -= 1; x *= 5; x /= 2; x", 5},
+		{`var s = "a"; s += "b"; s`, "ab"},
+		{"var x = 1; var f = fn() { x = 10; }; f(); x", 10},
+		{"var make = fn() { var n = 0; fn() { n += 1; n } }; var c = make(); c(); c(); c()", 3},
+		{"var f = fn() { var n = 0; var inc = fn() { n += 1 }; inc(); inc(); n }; f()", 2},
+		{"var fs = fn() { var n = 0; [fn() { n += 1 }, fn() { n }] }(); fs[0](); fs[0](); fs[1]()", 2},
+		{"var f = fn(x) { x += 1; x }; f(1)", 2},
+		{"var f = fn() { 1; var x = 2; x = 3 }; f()", Null},
+		{"var a = [1, 2, 3]; a[1] = 5; a[1] + a[2]", 8},
+		{"var a = [1, 2, 3]; var b = a; b[0] += 10; a[0]", 11},
+		{`var h = {"a": 1}; h["b"] = 2; h["a"] *= 7; h["a"] + h["b"]`, 9},
+		{"var n = 0; for (x in range(5)) { n += x; } n", 10},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestGlobalVarStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"var one = 1; one", 1},
+		{"var one = 1; var two = one + one; one + two", 3},
+		{"var a = 1; var a = a + 1; a", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCollections(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1 + 2, 3 * 4][1]", 12},
+		{"[1, 2, 3][99]", Null},
+		{`"monkey"[1]`, "o"},
+		{`{1: 2, "a": 3}["a"]`, 3},
+		{`{}[0]`, Null},
+		{`len([1, 2, 3])`, 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"var f = fn() { 5 + 10; }; f();", 15},
+		{"var f = fn() { return 1; 2 }; f();", 1},
+		{"var f = fn() { }; f();", Null},
+		{"var sum = fn(a, b) { var c = a + b; c; }; sum(1, 2) + sum(3, 4);", 10},
+		{"var g = 50; var f = fn(a) { var b = a; g - b }; f(1) + f(2);", 97},
+		{"return 10; 9", 10},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`var newAdder = fn(a, b) {
+				fn(c) { a + b + c };
+			};
+			var adder = newAdder(1, 2);
+			adder(8);`,
+			11,
+		},
+		{
+			`var countDown = fn(x) {
+				if (x == 0) { return 0; } else { countDown(x - 1); }
+			};
+			var wrapper = fn() { countDown(1); };
+			wrapper();`,
+			0,
+		},
+		{
+			`var wrapper = fn() {
+				var countDown = fn(x) {
+					if (x == 0) { return 0; } else { countDown(x - 1); }
+				};
+				countDown(1);
+			};
+			wrapper();`,
+			0,
+		},
+		{
+			`var fibonacci = fn(x) {
+				if (x == 0) { return 0; }
+				if (x == 1) { return 1; }
+				fibonacci(x - 1) + fibonacci(x - 2);
+			};
+			fibonacci(15);`,
+			610,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5 + true", "type mismatch: INTEGER + BOOLEAN"},
+		{"-true", "unknown operator: -BOOLEAN"},
+		{`"a" - "b"`, "unknown operator: STRING - STRING"},
+		{"fn(a) { a }()", "wrong number of arguments: want=1, got=0"},
+		{"1 / (2 - 2)", "division by zero"},
+		{"1()", "not a function: INTEGER"},
+		{"len(1)", "argument to `len` not supported, got INTEGER"},
+		{"{fn() {}: 1}", "unusable as hash key: FUNC"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err := vm.Run()
+		if err == nil {
+			t.Errorf("expected VM error for %q", tt.input)
+			continue
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestErrorTracebacks(t *testing.T) {
+	tests := []struct {
+		input     string
+		expected  string
+		traceback string
+	}{
+		{"1 + 2;\n-true", "error: 2:1: unknown operator: -BOOLEAN", ""},
+		{"var f = fn(x) {\n  x / 0\n};\nf(2)", "error: 2:3: division by zero",
+			"Traceback (most recent call last):\n  4:1, in <main>: f(2)\n"},
+		{"var g = fn(s) { s - 1 };\nvar f = fn() { 1 + g(\"a\") };\nf()", "error: 1:17: type mismatch: STRING - INTEGER",
+			"Traceback (most recent call last):\n  3:1, in <main>: f()\n  2:20, in f: g(\"a\")\n"},
+		{"map([1], fn(x) { len(x) })", "error: 1:18: argument to `len` not supported, got INTEGER",
+			"Traceback (most recent call last):\n  1:1, in <main>: map([1], fn)\n  1:18, in map: len(1)\n"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err := New(comp.Bytecode()).Run()
+		errObj, ok := err.(*object.Error)
+		if !ok {
+			t.Errorf("expected *object.Error for %q, got=%T (%v)", tt.input, err, err)
+			continue
+		}
+
+		if errObj.Inspect() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, tt.expected, errObj.Inspect())
+		}
+
+		if errObj.Traceback() != tt.traceback {
+			t.Errorf("wrong traceback for %q. want=%q, got=%q", tt.input, tt.traceback, errObj.Traceback())
+		}
+	}
+}
+
+func TestOutputBuiltins(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{`puts("a", 1, [true])`, "a\n1\n[true]\n"},
+		{`print("a", 1); print("b")`, "a 1b"},
+		{`printf("%s=%d\n", "x", 1)`, "x=1\n"},
+		{"var f = fn(x) { puts(x * 2) }; map([1, 2], f)", "2\n4\n"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		var out bytes.Buffer
+		vm := New(comp.Bytecode())
+		vm.SetStdout(&out)
+
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+
+		if out.String() != tt.output {
+			t.Errorf("wrong output for %q. want=%q, got=%q", tt.input, tt.output, out.String())
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input    string
+		limits   object.Limits
+		ctx      context.Context
+		expected object.ErrorKind
+	}{
+		{"while (true) { }", object.Limits{MaxSteps: 1000}, context.Background(), object.StepLimitError},
+		{"var f = fn(n) { f(n) }; f(1)", object.Limits{MaxDepth: 10}, context.Background(), object.DepthLimitError},
+		{"var f = fn(n) { map([n], f) }; f(1)", object.Limits{MaxDepth: 10}, context.Background(), object.DepthLimitError},
+		// the default depth, the stack of the VM grows until then
+		{"var f = fn() { f() }; f()", object.Limits{}, context.Background(), object.DepthLimitError},
+		{"var f = fn(n) { [n, f(n)] }; f(1)", object.Limits{}, context.Background(), object.DepthLimitError},
+		{"while (true) { }", object.Limits{}, canceled, object.CanceledError},
+		{"range(1000000)", object.Limits{MaxSteps: 1000}, context.Background(), object.StepLimitError},
+		{`format("%999999d", 1)`, object.Limits{MaxSteps: 1000}, context.Background(), object.StepLimitError},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		vm.SetLimits(tt.limits)
+
+		err := vm.RunContext(tt.ctx)
+		errObj, ok := err.(*object.Error)
+		if !ok {
+			t.Errorf("expected *object.Error for %q, got=%T (%v)", tt.input, err, err)
+			continue
+		}
+
+		if errObj.Kind != tt.expected {
+			t.Errorf("wrong error kind for %q. want=%d, got=%d (%s)", tt.input, tt.expected, errObj.Kind, errObj.Message)
+		}
+	}
+}
+
+// TestEngineParity runs the programs of the evaluator's tests, and a few more,
+// through the evaluator and the VM and checks that both produce the same value
+// or the same error at the same position.
+func TestEngineParity(t *testing.T) {
+	inputs := append(evaltest.Inputs(),
+		"if (10 > 1) { if (10 > 1) { return 10; } return 1; }",
+		`"Hello" + " " + "World!"`,
+		"var newAdder = fn(x) { fn(y) { x + y }; }; var addTwo = newAdder(2); addTwo(2);",
+		`var two = "two"; {"one": 10 - 9, two: 1 + 1, 4: 4, true: 5}`,
+		"true == 1",
+		"-2.5 * 2",
+		"-true + 1.5",
+		"1.5 + true",
+		"1.0 / 0",
+		"true <= false",
+		`"a" >= "b"`,
+		"true && 1 + true",
+		"false || 3 <= 3.0",
+		"var n = 0; for (k in {2: 1, 1: 2}) { var n = n * 10 + k; } n",
+		`var h = {}; h["k"] += 1`,
+		"var fs = []; var add = fn() { var i = 0; var out = [0, 0]; for (x in [1, 2]) { out[i] = fn() { x }; i += 1; } out }(); [add[0](), add[1]()]",
+		`var ñame = "ñandú"; [len(ñame), bytelen(ñame), ñame[4]]`,
+		"[first([1, 2]), last([1, 2]), rest([1, 2]), push([1], 2), first([])]",
+		`[concat([1], [2]), slice("abc", 1), reverse([1, 2]), sort([2, 1])]`,
+		"filter(range(10), fn(x) { x > 6 })",
+		"reduce([1, 2, 3], [], fn(acc, x) { push(acc, x * x) })",
+		"sort([3, 1, 2], fn(a, b) { a > b })",
+		"map([[1, 2], [3]], fn(xs) { reduce(xs, 0, fn(a, b) { a + b }) })",
+		"var count = 0; map([1, 2, 3], fn(x) { count += x; count }); count",
+		"map([1], fn(x) { rest([]) })",
+		"var n = 0; map([1, 2], fn(x) { n += x; }); n",
+		"var f = fn(x) {\n  x / 0\n};\nf(2)",
+		"var f = fn() { g() }; var g = fn() { 1 }; f()",
+		"var even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }; var odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; [even(10), odd(7)]",
+		"var f = fn() { g }; f()",
+		"var x = x",
+		"for (x in [1]) { }; x",
+		"var f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(9999)",
+		"var f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(10000)",
+		"var f = fn(n) { if (n == 0) { [] } else { [n, f(n - 1)] } }; len(f(5000))",
+	)
+
+	for _, input := range inputs {
+		expected, actual := runEngines(input)
+		if expected.Inspect() != actual.Inspect() {
+			t.Errorf("engines disagree on %q. eval=%q, vm=%q", input, expected.Inspect(), actual.Inspect())
+		}
+	}
+}
+
+// TestEngineParityOutput checks that both engines run a program up to its
+// first error, producing the same output.
+func TestEngineParityOutput(t *testing.T) {
+	input := `puts("a"); foo; puts("b")`
+	program := parse(input)
+
+	var evalOut bytes.Buffer
+	env := object.NewEnv()
+	env.SetStdout(&evalOut)
+	expected := eval.Eval(program, env)
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var vmOut bytes.Buffer
+	vm := New(comp.Bytecode())
+	vm.SetStdout(&vmOut)
+	actual := object.ErrorFrom(vm.Run())
+
+	if expected.Inspect() != actual.Inspect() {
+		t.Errorf("engines disagree on %q. eval=%q, vm=%q", input, expected.Inspect(), actual.Inspect())
+	}
+	if evalOut.String() != "a\n" || vmOut.String() != evalOut.String() {
+		t.Errorf("engines disagree on the output of %q. eval=%q, vm=%q", input, evalOut.String(), vmOut.String())
+	}
+}
+
+// runEngines runs input through the evaluator and the VM and returns their
+// results, errors included.
+func runEngines(input string) (evaluated, actual object.Object) {
+	program := parse(input)
+
+	evaluated = eval.Eval(program, object.NewEnv())
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err == nil {
+		vm := New(comp.Bytecode())
+		if err = vm.Run(); err == nil {
+			actual = vm.LastPoppedStackElem()
+		}
+	}
+	if err != nil {
+		actual = object.ErrorFrom(err)
+	}
+
+	return evaluated, actual
+}
+
+// TestOperandLimits checks that the engines agree on programs using as many
+// variables as the operands of the instructions can encode, and that the
+// compiler rejects programs using more rather than truncating the operands.
+func TestOperandLimits(t *testing.T) {
+	locals := func(n int) string {
+		var b strings.Builder
+		b.WriteString("fn() {")
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&b, " var l%d = %d;", i, i)
+		}
+		fmt.Fprintf(&b, " l%d }()", n-1)
+		return b.String()
+	}
+	globals := func(n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&b, "var g%d = true;\n", i)
+		}
+		fmt.Fprintf(&b, "var g%d = 0.5; g%d + %d", n-1, n-1, n-1)
+		return b.String()
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string // the error of the VM, if any
+	}{
+		{"256 locals", locals(256), ""},
+		{"257 locals", locals(257), fmt.Sprintf("error: 1:%d: too many local variables, the limit is 256", strings.Index(locals(257), "var l256")+1)},
+		{"65536 globals", globals(65536), ""},
+		{"65537 globals", globals(65537), "error: 65537:1: too many global variables, the limit is 65536"},
+	}
+
+	for _, tt := range tests {
+		evaluated, actual := runEngines(tt.input)
+		if _, ok := evaluated.(*object.Error); ok {
+			t.Fatalf("%s: evaluator error: %s", tt.name, evaluated.Inspect())
+		}
+
+		expected := evaluated.Inspect()
+		if tt.expected != "" {
+			expected = tt.expected
+		}
+		if actual.Inspect() != expected {
+			t.Errorf("%s: wrong VM result. want=%q, got=%q", tt.name, expected, actual.Inspect())
+		}
+	}
+}
+
+func runVmTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+
+		testExpectedObject(t, tt.input, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func testExpectedObject(t *testing.T, input string, expected interface{}, actual object.Object) {
+	t.Helper()
+
+	switch expected := expected.(type) {
+	case int:
+		integer, ok := actual.(*object.Integer)
+		if !ok || integer.Value != int64(expected) {
+			t.Errorf("%q: object is not Integer %d. got=%T (%+v)", input, expected, actual, actual)
+		}
+	case bool:
+		boolean, ok := actual.(*object.Boolean)
+		if !ok || boolean.Value != expected {
+			t.Errorf("%q: object is not Boolean %t. got=%T (%+v)", input, expected, actual, actual)
+		}
+	case string:
+		str, ok := actual.(*object.String)
+		if !ok || str.Value != expected {
+			t.Errorf("%q: object is not String %q. got=%T (%+v)", input, expected, actual, actual)
+		}
+	case *object.Null:
+		if actual != Null {
+			t.Errorf("%q: object is not Null. got=%T (%+v)", input, actual, actual)
+		}
+	}
+}